@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"runtime"
@@ -12,11 +13,13 @@ import (
 	"github.com/deis/builder/pkg/gitreceive"
 	"github.com/deis/builder/pkg/healthsrv"
 	"github.com/deis/builder/pkg/sshd"
+	"github.com/deis/builder/pkg/storage"
 	"github.com/deis/builder/pkg/sys"
 	pkglog "github.com/deis/pkg/log"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	_ "github.com/docker/distribution/registry/storage/driver/azure"
 	"github.com/docker/distribution/registry/storage/driver/factory"
+	_ "github.com/docker/distribution/registry/storage/driver/filesystem"
 	_ "github.com/docker/distribution/registry/storage/driver/gcs"
 	_ "github.com/docker/distribution/registry/storage/driver/s3-aws"
 	_ "github.com/docker/distribution/registry/storage/driver/swift"
@@ -63,16 +66,15 @@ func main() {
 					log.Printf("Error getting storage parameters (%s)", err)
 					os.Exit(1)
 				}
-				var storageDriver storagedriver.StorageDriver
-				if cnf.StorageType == "minio" {
-					storageDriver, err = factory.Create("s3", storageParams)
-				} else {
-					storageDriver, err = factory.Create(cnf.StorageType, storageParams)
-				}
+				storageDriver, err := newStorageDriver(cnf.StorageType, storageParams)
 				if err != nil {
 					log.Printf("Error creating storage driver (%s)", err)
 					os.Exit(1)
 				}
+				if storageDriver, err = withEncryption(env, storageDriver); err != nil {
+					log.Printf("Error setting up storage encryption (%s)", err)
+					os.Exit(1)
+				}
 
 				kubeClient, err := kcl.NewInCluster()
 				if err != nil {
@@ -82,14 +84,20 @@ func main() {
 				log.Printf("Starting health check server on port %d", cnf.HealthSrvPort)
 				healthSrvCh := make(chan error)
 				go func() {
-					if err := healthsrv.Start(cnf, kubeClient.Namespaces(), storageDriver, circ); err != nil {
+					if err := healthsrv.Start(cnf, kubeClient.Namespaces(), storageDriver, storageDriver, storageDriver, circ); err != nil {
 						healthSrvCh <- err
 					}
 				}()
 				log.Printf("Starting deleted app cleaner")
+				cachePolicy := cleaner.CachePolicy{MaxAge: cnf.BuildpackCacheMaxAge(), MaxSize: cnf.BuildpackCacheMaxSize()}
+				retention := cleaner.RetentionPolicy{
+					KeepLast: cnf.BuildRetentionKeepLast,
+					MaxAge:   cnf.BuildRetentionMaxAge(),
+					Registry: cnf.BuildRetentionRegistry,
+				}
 				cleanerErrCh := make(chan error)
 				go func() {
-					if err := cleaner.Run(gitHomeDir, kubeClient.Namespaces(), fs, cnf.CleanerPollSleepDuration(), storageDriver); err != nil {
+					if err := cleaner.Run(gitHomeDir, kubeClient.Namespaces(), fs, cnf.CleanerPollSleepDuration(), storageDriver, cnf.BuildLogRetention(), cachePolicy, retention); err != nil {
 						cleanerErrCh <- err
 					}
 				}()
@@ -97,7 +105,7 @@ func main() {
 				log.Printf("Starting SSH server on %s:%d", cnf.SSHHostIP, cnf.SSHHostPort)
 				sshCh := make(chan int)
 				go func() {
-					sshCh <- pkg.RunBuilder(cnf, gitHomeDir, circ, pushLock)
+					sshCh <- pkg.RunBuilder(cnf, gitHomeDir, circ, pushLock, storageDriver, storageDriver)
 				}()
 
 				select {
@@ -113,6 +121,73 @@ func main() {
 				}
 			},
 		},
+		{
+			Name:  "storage",
+			Usage: "Manage the objects of the builder in object storage",
+			Subcommands: []cli.Command{
+				{
+					Name:  "migrate",
+					Usage: "Copy the objects of the builder from one object storage backend to another",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:   "from",
+							Usage:  "type of the object storage to copy from",
+							EnvVar: "BUILDER_STORAGE",
+						},
+						cli.StringFlag{
+							Name:  "from-creds",
+							Value: conf.StorageCredLocation,
+							Usage: "folder holding the credentials of the object storage to copy from, laid out like the objectstorage-keyfile secret",
+						},
+						cli.StringFlag{
+							Name:  "to",
+							Usage: "type of the object storage to copy to",
+						},
+						cli.StringFlag{
+							Name:  "to-creds",
+							Usage: "folder holding the credentials of the object storage to copy to, laid out like the objectstorage-keyfile secret",
+						},
+						cli.StringFlag{
+							Name:  "state",
+							Value: "/tmp/storage-migrate.state",
+							Usage: "file recording the objects already copied, to resume an interrupted migration",
+						},
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "list the objects to copy without copying them",
+						},
+					},
+					Action: func(c *cli.Context) {
+						if c.String("from") == "" || c.String("to") == "" || c.String("to-creds") == "" {
+							log.Printf("--from, --to and --to-creds are required")
+							os.Exit(1)
+						}
+						env := sys.RealEnv()
+						src, err := newStorageDriverFrom(env, c.String("from"), c.String("from-creds"))
+						if err != nil {
+							log.Printf("Error creating the source storage driver (%s)", err)
+							os.Exit(1)
+						}
+						dst, err := newStorageDriverFrom(env, c.String("to"), c.String("to-creds"))
+						if err != nil {
+							log.Printf("Error creating the destination storage driver (%s)", err)
+							os.Exit(1)
+						}
+						opts := storage.MigrateOptions{DryRun: c.Bool("dry-run"), StatePath: c.String("state")}
+						stats, err := storage.Migrate(src, dst, storage.BuilderRoot, opts, os.Stdout)
+						if err != nil {
+							log.Printf("Error migrating objects (%s)", err)
+							os.Exit(1)
+						}
+						if opts.DryRun {
+							log.Printf("%d bytes to copy", stats.Bytes)
+							return
+						}
+						log.Printf("Copied %d objects (%d bytes), skipped %d already copied", stats.Copied, stats.Bytes, stats.Skipped)
+					},
+				},
+			},
+		},
 		{
 			Name:    "git-receive",
 			Aliases: []string{"gr"},
@@ -131,16 +206,15 @@ func main() {
 					log.Printf("Error getting storage parameters (%s)", err)
 					os.Exit(1)
 				}
-				var storageDriver storagedriver.StorageDriver
-				if cnf.StorageType == "minio" {
-					storageDriver, err = factory.Create("s3", storageParams)
-				} else {
-					storageDriver, err = factory.Create(cnf.StorageType, storageParams)
-				}
+				storageDriver, err := newStorageDriver(cnf.StorageType, storageParams)
 				if err != nil {
 					log.Printf("Error creating storage driver (%s)", err)
 					os.Exit(1)
 				}
+				if storageDriver, err = withEncryption(env, storageDriver); err != nil {
+					log.Printf("Error setting up storage encryption (%s)", err)
+					os.Exit(1)
+				}
 
 				if err := gitreceive.Run(cnf, fs, env, storageDriver); err != nil {
 					log.Printf("Error running git receive hook [%s]", err)
@@ -152,3 +226,35 @@ func main() {
 
 	app.Run(os.Args)
 }
+
+// newStorageDriver returns the storage driver of type storageType, with the given parameters.
+func newStorageDriver(storageType string, params conf.Parameters) (storagedriver.StorageDriver, error) {
+	if storageType == "minio" {
+		return factory.Create("s3", params)
+	}
+	return factory.Create(storageType, params)
+}
+
+// newStorageDriverFrom returns the storage driver of type storageType, with the credentials in
+// credLocation.
+func newStorageDriverFrom(env sys.Env, storageType, credLocation string) (storagedriver.StorageDriver, error) {
+	params, err := conf.GetStorageParamsFrom(env, storageType, credLocation)
+	if err != nil {
+		return nil, fmt.Errorf("getting storage parameters (%s)", err)
+	}
+	return newStorageDriver(storageType, params)
+}
+
+// withEncryption wraps storageDriver to encrypt tarballs, slugs and caches at rest if
+// BUILDER_ENCRYPTION_KEY_ID names a key of the encryption keys secret.
+func withEncryption(env sys.Env, storageDriver storagedriver.StorageDriver) (storagedriver.StorageDriver, error) {
+	keyID := env.Get(storage.EncryptionKeyIDEnvVar)
+	if keyID == "" {
+		return storageDriver, nil
+	}
+	keyring, err := storage.LoadKeyring(storage.EncryptionKeysLocation, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewEncryptedDriver(storageDriver, keyring), nil
+}
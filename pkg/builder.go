@@ -8,6 +8,7 @@ import (
 	"fmt"
 
 	"github.com/deis/builder/pkg/sshd"
+	"github.com/deis/builder/pkg/storage"
 	"github.com/deis/pkg/log"
 )
 
@@ -25,7 +26,7 @@ const (
 // Git.
 //
 // Run returns on of the Status* status code constants.
-func RunBuilder(cnf *sshd.Config, gitHomeDir string, sshServerCircuit *sshd.Circuit, pushLock sshd.RepositoryLock) int {
+func RunBuilder(cnf *sshd.Config, gitHomeDir string, sshServerCircuit *sshd.Circuit, pushLock sshd.RepositoryLock, objGetter storage.ObjectGetter, objDeleter storage.ObjectDeleter) int {
 	address := fmt.Sprintf("%s:%d", cnf.SSHHostIP, cnf.SSHHostPort)
 	cfg, err := sshd.Configure(cnf)
 	if err != nil {
@@ -33,7 +34,7 @@ func RunBuilder(cnf *sshd.Config, gitHomeDir string, sshServerCircuit *sshd.Circ
 		return StatusLocalError
 	}
 	receivetype := "gitreceive"
-	if err := sshd.Serve(cfg, sshServerCircuit, gitHomeDir, pushLock, address, receivetype); err != nil {
+	if err := sshd.Serve(cfg, sshServerCircuit, gitHomeDir, pushLock, address, receivetype, objGetter, objDeleter); err != nil {
 		log.Err("SSH server failed: %s", err)
 		return StatusLocalError
 	}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/deis/builder/pkg/gitreceive"
 	"github.com/deis/builder/pkg/k8s"
+	"github.com/deis/builder/pkg/storage"
 	"github.com/deis/builder/pkg/sys"
 	"github.com/deis/pkg/log"
 	"github.com/docker/distribution/context"
@@ -23,8 +24,33 @@ import (
 
 const (
 	dotGitSuffix = ".git"
+	// buildLogSweepInterval is how often the cleaner looks for expired build logs of live apps
+	buildLogSweepInterval = time.Hour
+	// cacheSweepInterval is how often the cleaner enforces the CachePolicy on the buildpack caches
+	// of live apps
+	cacheSweepInterval = time.Hour
 )
 
+// CachePolicy limits the buildpack caches kept in object storage. A cache that wasn't used for
+// longer than MaxAge, or that grew larger than MaxSize bytes, is deleted so that the next build
+// starts from scratch. A zero value disables the corresponding limit.
+type CachePolicy struct {
+	MaxAge  time.Duration
+	MaxSize int64
+}
+
+func (p CachePolicy) enabled() bool {
+	return p.MaxAge > 0 || p.MaxSize > 0
+}
+
+// gitShaPattern matches the git sha of the object storage folder of a build, the short one of the
+// v1 object key layout or the full one of the v2 layout.
+const gitShaPattern = `(?:[0-9a-f]{8}|[0-9a-f]{40})`
+
+// gitKeyRegex matches the object storage folders of every build, capturing the app name and git
+// sha. It needs a prepended / to match output of List()
+var gitKeyRegex = regexp.MustCompile(`^/` + fmt.Sprintf(gitreceive.GitKeyPattern, `([^/:]+)`, `(`+gitShaPattern+`)`) + `$`)
+
 // localDirs returns all of the local directories immediately under gitHome that filter returns true for.
 // filter will receive only the names of each of the top level directories (not their fully qualified paths), and should return true if it should be included in the output
 func localDirs(gitHome string, filter func(string) bool) ([]string, error) {
@@ -86,13 +112,25 @@ func dirHasGitSuffix(dir string) bool {
 
 func deleteFromObjectStore(app string, storageDriver storagedriver.StorageDriver) error {
 
-	cacheKey := fmt.Sprintf(gitreceive.CacheKeyPattern, app)
+	cacheKeys := []string{
+		fmt.Sprintf(gitreceive.CacheKeyPattern, app),
+		fmt.Sprintf(gitreceive.BranchCachesKeyPattern, app),
+		fmt.Sprintf(gitreceive.DockerCacheKeyPattern, app),
+		fmt.Sprintf(gitreceive.CacheMetadataKeyPattern, app),
+		storage.ChecksumKey(fmt.Sprintf(gitreceive.CacheMetadataKeyPattern, app)),
+		fmt.Sprintf(gitreceive.LastBuildKeyPattern, app),
+		storage.ChecksumKey(fmt.Sprintf(gitreceive.LastBuildKeyPattern, app)),
+		fmt.Sprintf(gitreceive.ReleasesKeyPattern, app),
+		storage.ChecksumKey(fmt.Sprintf(gitreceive.ReleasesKeyPattern, app)),
+	}
 
-	// if cache file exists, delete it
-	if _, err := storageDriver.Stat(context.Background(), cacheKey); err == nil {
-		log.Info("Cleaner deleting cache %s for app %s", cacheKey, app)
-		if err := storageDriver.Delete(context.Background(), cacheKey); err != nil {
-			return err
+	// if cache files and the build records exist, delete them
+	for _, cacheKey := range cacheKeys {
+		if _, err := storageDriver.Stat(context.Background(), cacheKey); err == nil {
+			log.Info("Cleaner deleting %s for app %s", cacheKey, app)
+			if err := storageDriver.Delete(context.Background(), cacheKey); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -103,7 +141,7 @@ func deleteFromObjectStore(app string, storageDriver storagedriver.StorageDriver
 	}
 
 	// regex needs prepended / to match output of List()
-	gitRegex, err := regexp.Compile(`^/` + fmt.Sprintf(gitreceive.GitKeyPattern, app, ".{8}") + "$")
+	gitRegex, err := regexp.Compile(`^/` + fmt.Sprintf(gitreceive.GitKeyPattern, regexp.QuoteMeta(app), gitShaPattern) + "$")
 	if err != nil {
 		return err
 	}
@@ -119,10 +157,136 @@ func deleteFromObjectStore(app string, storageDriver storagedriver.StorageDriver
 	return nil
 }
 
+// deleteExpiredBuildLogs deletes the build logs of every app that were last written before
+// expiry.
+func deleteExpiredBuildLogs(storageDriver storagedriver.StorageDriver, expiry time.Time) error {
+	objs, err := storageDriver.List(context.Background(), "home")
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objs {
+		matches := gitKeyRegex.FindStringSubmatch(obj)
+		if matches == nil {
+			continue
+		}
+		// keep the prepended / so the key matches the output of List()
+		logKey := "/" + gitreceive.BuildLogKey(matches[1], matches[2])
+		info, err := storageDriver.Stat(context.Background(), logKey)
+		if err != nil || info.ModTime().After(expiry) {
+			continue
+		}
+		log.Info("Cleaner deleting build log %s for app %s", logKey, matches[1])
+		if err := storageDriver.Delete(context.Background(), logKey); err != nil {
+			return err
+		}
+		err = storageDriver.Delete(context.Background(), storage.ChecksumKey(logKey))
+		if _, ok := err.(storagedriver.PathNotFoundError); err != nil && !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteStaleCaches deletes the buildpack caches of every app and branch that break policy at
+// now.
+func deleteStaleCaches(storageDriver storagedriver.StorageDriver, policy CachePolicy, now time.Time) error {
+	objs, err := storageDriver.List(context.Background(), "home")
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objs {
+		app := strings.TrimPrefix(obj, "/home/")
+		if app == obj || strings.ContainsAny(app, ":/") {
+			// not an app folder, but the folder of a build
+			continue
+		}
+		// keep the prepended / so the keys match the output of List()
+		cacheKeys := []string{"/" + fmt.Sprintf(gitreceive.CacheKeyPattern, app)}
+		branchCaches, err := storageDriver.List(context.Background(), "/"+fmt.Sprintf(gitreceive.BranchCachesKeyPattern, app))
+		if _, ok := err.(storagedriver.PathNotFoundError); err != nil && !ok {
+			return err
+		}
+		for _, key := range branchCaches {
+			if !gitreceive.IsCacheMetadataKey(key) {
+				cacheKeys = append(cacheKeys, key)
+			}
+		}
+		for _, cacheKey := range cacheKeys {
+			if err := deleteStaleCache(storageDriver, policy, now, app, cacheKey); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteStaleCache deletes the buildpack cache of app at cacheKey, along with its metadata, if it
+// breaks policy at now.
+func deleteStaleCache(storageDriver storagedriver.StorageDriver, policy CachePolicy, now time.Time, app, cacheKey string) error {
+	info, err := storageDriver.Stat(context.Background(), cacheKey)
+	if err != nil {
+		return nil
+	}
+	lastUsed := info.ModTime()
+	if metadata, err := gitreceive.GetCacheMetadata(storageDriver, cacheKey); err == nil {
+		lastUsed = metadata.LastUsed
+	}
+
+	var reason string
+	switch {
+	case policy.MaxSize > 0 && info.Size() > policy.MaxSize:
+		reason = fmt.Sprintf("it is larger than %d bytes", policy.MaxSize)
+	case policy.MaxAge > 0 && now.Sub(lastUsed) > policy.MaxAge:
+		reason = fmt.Sprintf("it was last used on %s", lastUsed.Format(time.RFC3339))
+	default:
+		return nil
+	}
+	log.Info("Cleaner deleting cache %s for app %s because %s", cacheKey, app, reason)
+	metadataKey := gitreceive.CacheMetadataKey(cacheKey)
+	for _, key := range []string{cacheKey, metadataKey, storage.ChecksumKey(metadataKey)} {
+		err := storageDriver.Delete(context.Background(), key)
+		if _, ok := err.(storagedriver.PathNotFoundError); err != nil && !ok {
+			return err
+		}
+	}
+	return nil
+}
+
 // Run starts the deleted app cleaner. Every pollSleepDuration, it compares the result of nsLister.List with the directories in the top level of gitHome on the local file system.
+// Once every buildLogSweepInterval, it also deletes the build logs older than buildLogRetention,
+// unless buildLogRetention is 0, once every cacheSweepInterval the buildpack caches that break
+// cachePolicy, and once every retentionSweepInterval the builds that retention doesn't keep.
 // On any error, it uses log messages to output a human readable description of what happened.
-func Run(gitHome string, nsLister k8s.NamespaceLister, fs sys.FS, pollSleepDuration time.Duration, storageDriver storagedriver.StorageDriver) error {
+func Run(gitHome string, nsLister k8s.NamespaceLister, fs sys.FS, pollSleepDuration time.Duration, storageDriver storagedriver.StorageDriver, buildLogRetention time.Duration, cachePolicy CachePolicy, retention RetentionPolicy) error {
+	var registry *registryClient
+	if retention.Registry != "" {
+		registry = newRegistryClient(retention.Registry)
+	}
+	var lastBuildLogSweep, lastCacheSweep, lastRetentionSweep time.Time
 	for {
+		if buildLogRetention > 0 && time.Since(lastBuildLogSweep) >= buildLogSweepInterval {
+			lastBuildLogSweep = time.Now()
+			if err := deleteExpiredBuildLogs(storageDriver, lastBuildLogSweep.Add(-buildLogRetention)); err != nil {
+				log.Err("Cleaner error removing expired build logs (%s)", err)
+			}
+		}
+
+		if cachePolicy.enabled() && time.Since(lastCacheSweep) >= cacheSweepInterval {
+			lastCacheSweep = time.Now()
+			if err := deleteStaleCaches(storageDriver, cachePolicy, lastCacheSweep); err != nil {
+				log.Err("Cleaner error removing stale build caches (%s)", err)
+			}
+		}
+
+		if retention.enabled() && time.Since(lastRetentionSweep) >= retentionSweepInterval {
+			lastRetentionSweep = time.Now()
+			if err := enforceRetentionPolicy(storageDriver, registry, retention, lastRetentionSweep); err != nil {
+				log.Err("Cleaner error removing expired builds (%s)", err)
+			}
+		}
+
 		nsList, err := nsLister.List(api.ListOptions{LabelSelector: labels.Everything(), FieldSelector: fields.Everything()})
 		if err != nil {
 			log.Err("Cleaner error listing namespaces (%s)", err)
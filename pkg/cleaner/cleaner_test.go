@@ -5,8 +5,12 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/arschles/assert"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+	_ "github.com/docker/distribution/registry/storage/driver/inmemory"
 	"k8s.io/kubernetes/pkg/api"
 )
 
@@ -79,3 +83,77 @@ func TestStripSuffixes(t *testing.T) {
 		assert.False(t, strings.HasSuffix(str, dotGitSuffix), "string %s has suffix %s", str, dotGitSuffix)
 	}
 }
+
+func TestGitKeyRegex(t *testing.T) {
+	matches := gitKeyRegex.FindStringSubmatch("/home/myapp:git-c3b4e4ba")
+	assert.Equal(t, matches, []string{"/home/myapp:git-c3b4e4ba", "myapp", "c3b4e4ba"}, "matches")
+	assert.False(t, gitKeyRegex.MatchString("/home/myapp/cache"), "cache folder matched")
+	matches = gitKeyRegex.FindStringSubmatch("/home/myapp:git-c3b4e4ba5d1a0b0c0d0e0f000102030405060708")
+	assert.Equal(t, matches[2], "c3b4e4ba5d1a0b0c0d0e0f000102030405060708", "full sha")
+}
+
+func TestDeleteFromObjectStore(t *testing.T) {
+	storageDriver, err := factory.Create("inmemory", nil)
+	assert.NoErr(t, err)
+	legacyKey := "/home/myapp:git-c3b4e4ba/tar"
+	fullKey := "/home/myapp:git-c3b4e4ba5d1a0b0c0d0e0f000102030405060708/tar"
+	otherKey := "/home/myapp2:git-c3b4e4ba/tar"
+	for _, key := range []string{legacyKey, fullKey, otherKey} {
+		assert.NoErr(t, storageDriver.PutContent(context.Background(), key, []byte("tar")))
+	}
+
+	assert.NoErr(t, deleteFromObjectStore("myapp", storageDriver))
+	_, err = storageDriver.Stat(context.Background(), legacyKey)
+	assert.True(t, err != nil, "build stored with the short sha layout was not deleted")
+	_, err = storageDriver.Stat(context.Background(), fullKey)
+	assert.True(t, err != nil, "build stored with the full sha layout was not deleted")
+	_, err = storageDriver.Stat(context.Background(), otherKey)
+	assert.NoErr(t, err)
+}
+
+func TestDeleteExpiredBuildLogs(t *testing.T) {
+	storageDriver, err := factory.Create("inmemory", nil)
+	assert.NoErr(t, err)
+	logKey := "/home/myapp:git-c3b4e4ba/log.gz"
+	tarKey := "/home/myapp:git-c3b4e4ba/tar"
+	assert.NoErr(t, storageDriver.PutContent(context.Background(), logKey, []byte("log")))
+	assert.NoErr(t, storageDriver.PutContent(context.Background(), tarKey, []byte("tar")))
+
+	assert.NoErr(t, deleteExpiredBuildLogs(storageDriver, time.Now().Add(-time.Hour)))
+	_, err = storageDriver.Stat(context.Background(), logKey)
+	assert.NoErr(t, err)
+
+	assert.NoErr(t, deleteExpiredBuildLogs(storageDriver, time.Now().Add(time.Hour)))
+	_, err = storageDriver.Stat(context.Background(), logKey)
+	assert.True(t, err != nil, "expired build log was not deleted")
+	_, err = storageDriver.Stat(context.Background(), tarKey)
+	assert.NoErr(t, err)
+}
+
+func TestDeleteStaleCaches(t *testing.T) {
+	storageDriver, err := factory.Create("inmemory", nil)
+	assert.NoErr(t, err)
+	bigCacheKey := "/home/bigapp/cache"
+	smallCacheKey := "/home/smallapp/cache"
+	bigBranchCacheKey := "/home/smallapp/caches/feature-login"
+	tarKey := "/home/smallapp:git-c3b4e4ba/tar"
+	assert.NoErr(t, storageDriver.PutContent(context.Background(), bigCacheKey, []byte("a large cache")))
+	assert.NoErr(t, storageDriver.PutContent(context.Background(), bigBranchCacheKey, []byte("a large cache")))
+	assert.NoErr(t, storageDriver.PutContent(context.Background(), smallCacheKey, []byte("cache")))
+	assert.NoErr(t, storageDriver.PutContent(context.Background(), tarKey, []byte("tar")))
+
+	policy := CachePolicy{MaxAge: time.Hour, MaxSize: 10}
+	assert.NoErr(t, deleteStaleCaches(storageDriver, policy, time.Now()))
+	_, err = storageDriver.Stat(context.Background(), bigCacheKey)
+	assert.True(t, err != nil, "oversized cache was not deleted")
+	_, err = storageDriver.Stat(context.Background(), bigBranchCacheKey)
+	assert.True(t, err != nil, "oversized branch cache was not deleted")
+	_, err = storageDriver.Stat(context.Background(), smallCacheKey)
+	assert.NoErr(t, err)
+
+	assert.NoErr(t, deleteStaleCaches(storageDriver, policy, time.Now().Add(2*time.Hour)))
+	_, err = storageDriver.Stat(context.Background(), smallCacheKey)
+	assert.True(t, err != nil, "unused cache was not deleted")
+	_, err = storageDriver.Stat(context.Background(), tarKey)
+	assert.NoErr(t, err)
+}
@@ -0,0 +1,266 @@
+package cleaner
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deis/builder/pkg/gitreceive"
+	"github.com/deis/builder/pkg/storage"
+	"github.com/deis/pkg/log"
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+const (
+	// retentionSweepInterval is how often the cleaner enforces the RetentionPolicy on the builds of
+	// live apps
+	retentionSweepInterval = time.Hour
+
+	manifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// RetentionPolicy limits the builds of every app kept in object storage, and in the registry if
+// Registry is set. A build is kept if it's one of the KeepLast latest builds of its app, or if it's
+// newer than MaxAge. A zero value disables the corresponding rule, and a zero policy keeps every
+// build. Builds used by the latest releases of their app, as recorded by the builder, are always
+// kept.
+type RetentionPolicy struct {
+	KeepLast int
+	MaxAge   time.Duration
+	// Registry is the host[:port] of the on-cluster registry to delete the images of expired builds
+	// from, using the registry API. It must have deletion enabled. Images are kept if it's empty.
+	Registry string
+}
+
+func (p RetentionPolicy) enabled() bool {
+	return p.KeepLast > 0 || p.MaxAge > 0
+}
+
+// appBuild is a build stored in object storage.
+type appBuild struct {
+	app string
+	sha string
+	// key is the folder of the build, with the prepended / of the output of List()
+	key     string
+	modTime time.Time
+}
+
+// listAppBuilds returns the builds of every app in object storage, newest first.
+func listAppBuilds(storageDriver storagedriver.StorageDriver) (map[string][]appBuild, error) {
+	objs, err := storageDriver.List(context.Background(), "home")
+	if err != nil {
+		return nil, err
+	}
+	builds := make(map[string][]appBuild)
+	for _, obj := range objs {
+		matches := gitKeyRegex.FindStringSubmatch(obj)
+		if matches == nil {
+			continue
+		}
+		build := appBuild{app: matches[1], sha: matches[2], key: obj}
+		// folders have no reliable modification time in every storage backend, so the build is dated
+		// by the source tarball or the log it always has
+		for _, name := range []string{"tar", "log.gz"} {
+			if info, err := storageDriver.Stat(context.Background(), obj+"/"+name); err == nil {
+				build.modTime = info.ModTime()
+				break
+			}
+		}
+		if build.modTime.IsZero() {
+			log.Debug("Cleaner can't date build %s, keeping it", obj)
+			continue
+		}
+		builds[build.app] = append(builds[build.app], build)
+	}
+	for _, appBuilds := range builds {
+		sort.Sort(byNewest(appBuilds))
+	}
+	return builds, nil
+}
+
+type byNewest []appBuild
+
+func (b byNewest) Len() int           { return len(b) }
+func (b byNewest) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byNewest) Less(i, j int) bool { return b[i].modTime.After(b[j].modTime) }
+
+// releaseRefs returns the images and the git shas of the builds of the latest releases of app,
+// as recorded by the builder when publishing them. It returns an error if no release of app is
+// recorded, since the builds it uses can't be told apart then.
+func releaseRefs(getter storage.ObjectGetter, app string) ([]string, map[string]struct{}, error) {
+	releases, err := gitreceive.RecordedReleases(getter, app)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(releases) == 0 {
+		return nil, nil, fmt.Errorf("no release recorded")
+	}
+	var images []string
+	shas := make(map[string]struct{})
+	for _, release := range releases {
+		// releases are tagged with their version in the registry
+		images = append(images, fmt.Sprintf("%s:v%d", app, release.Version))
+		shas[release.Sha] = struct{}{}
+	}
+	return images, shas, nil
+}
+
+// usedByRelease returns true if the build keyed by sha is referenced by releaseShas. Either may
+// be a short or a full git sha.
+func usedByRelease(sha string, releaseShas map[string]struct{}) bool {
+	for releaseSha := range releaseShas {
+		if strings.HasPrefix(sha, releaseSha) || strings.HasPrefix(releaseSha, sha) {
+			return true
+		}
+	}
+	return false
+}
+
+// expiredBuilds returns the builds, newest first, that policy doesn't keep at now.
+func expiredBuilds(builds []appBuild, policy RetentionPolicy, releaseShas map[string]struct{}, now time.Time) []appBuild {
+	var expired []appBuild
+	for i, build := range builds {
+		if policy.KeepLast > 0 && i < policy.KeepLast {
+			continue
+		}
+		if policy.MaxAge > 0 && now.Sub(build.modTime) <= policy.MaxAge {
+			continue
+		}
+		if usedByRelease(build.sha, releaseShas) {
+			continue
+		}
+		expired = append(expired, build)
+	}
+	return expired
+}
+
+// enforceRetentionPolicy deletes the builds of every app that policy doesn't keep at now.
+func enforceRetentionPolicy(storageDriver storagedriver.StorageDriver, registry *registryClient, policy RetentionPolicy, now time.Time) error {
+	storedBuilds, err := listAppBuilds(storageDriver)
+	if err != nil {
+		return err
+	}
+	for app, appBuilds := range storedBuilds {
+		releaseImages, releaseShas, err := releaseRefs(storageDriver, app)
+		if err != nil {
+			log.Err("Cleaner skipping the retention policy for app %s (%s)", app, err)
+			continue
+		}
+		expired := expiredBuilds(appBuilds, policy, releaseShas, now)
+		if len(expired) == 0 {
+			continue
+		}
+
+		// without the digests of the releases, images can't be deleted safely
+		appRegistry := registry
+		var releaseDigests map[string]struct{}
+		if appRegistry != nil {
+			if releaseDigests, err = appRegistry.releaseDigests(app, releaseImages); err != nil {
+				log.Err("Cleaner error getting the image digests of the releases of app %s (%s)", app, err)
+				appRegistry = nil
+			}
+		}
+
+		for _, build := range expired {
+			if appRegistry != nil {
+				if err := appRegistry.deleteImage(app, "git-"+build.sha, releaseDigests); err != nil {
+					log.Err("Cleaner error deleting the image of build %s for app %s (%s)", build.sha, app, err)
+				}
+			}
+			log.Info("Cleaner deleting build %s for app %s, last written on %s", build.key, app, build.modTime.Format(time.RFC3339))
+			if err := storageDriver.Delete(context.Background(), build.key); err != nil {
+				log.Err("Cleaner error deleting build %s for app %s (%s)", build.key, app, err)
+			}
+		}
+	}
+	return nil
+}
+
+// registryClient deletes images from a registry using the registry v2 API.
+type registryClient struct {
+	client  *http.Client
+	baseURL string
+}
+
+func newRegistryClient(registry string) *registryClient {
+	return &registryClient{client: &http.Client{Timeout: 30 * time.Second}, baseURL: "http://" + registry}
+}
+
+// digest returns the digest of the manifest of repository:reference, or "" if there is none.
+func (r *registryClient) digest(repository, reference string) (string, error) {
+	req, err := http.NewRequest("HEAD", fmt.Sprintf("%s/v2/%s/manifests/%s", r.baseURL, repository, reference), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", manifestMediaType)
+	res, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res.Header.Get("Docker-Content-Digest"), nil
+	case http.StatusNotFound:
+		return "", nil
+	default:
+		return "", fmt.Errorf("registry returned %s for %s:%s", res.Status, repository, reference)
+	}
+}
+
+// releaseDigests returns the digests of the images of repository among releaseImages. Releases are
+// tagged with their version, so they may share their digest with the git-<sha> tag of a build.
+func (r *registryClient) releaseDigests(repository string, releaseImages []string) (map[string]struct{}, error) {
+	digests := make(map[string]struct{})
+	for _, image := range releaseImages {
+		idx := strings.LastIndex(image, ":")
+		if idx < 0 || strings.Contains(image[idx:], "/") {
+			continue
+		}
+		name, tag := image[:idx], image[idx+1:]
+		if name != repository && !strings.HasSuffix(name, "/"+repository) {
+			continue
+		}
+		digest, err := r.digest(repository, tag)
+		if err != nil {
+			return nil, err
+		}
+		if digest != "" {
+			digests[digest] = struct{}{}
+		}
+	}
+	return digests, nil
+}
+
+// deleteImage deletes repository:tag from the registry, unless its digest is one of keep.
+// Deleting a manifest deletes every tag pointing to it.
+func (r *registryClient) deleteImage(repository, tag string, keep map[string]struct{}) error {
+	digest, err := r.digest(repository, tag)
+	if err != nil || digest == "" {
+		return err
+	}
+	if _, ok := keep[digest]; ok {
+		return nil
+	}
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/v2/%s/manifests/%s", r.baseURL, repository, digest), nil)
+	if err != nil {
+		return err
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusAccepted, http.StatusOK:
+		log.Info("Cleaner deleted image %s:%s (%s)", repository, tag, digest)
+		return nil
+	case http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("registry returned %s deleting %s:%s", res.Status, repository, tag)
+	}
+}
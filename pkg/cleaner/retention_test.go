@@ -0,0 +1,131 @@
+package cleaner
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arschles/assert"
+	"github.com/deis/builder/pkg/gitreceive"
+	"github.com/deis/builder/pkg/storage"
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+)
+
+// recordReleases writes releases, lines of v<version> <sha>, as the releases of app recorded by
+// the builder.
+func recordReleases(t *testing.T, storageDriver storagedriver.StorageDriver, app, releases string) {
+	_, err := storage.PutContentWithChecksum(storageDriver, fmt.Sprintf(gitreceive.ReleasesKeyPattern, app), []byte(releases))
+	assert.NoErr(t, err)
+}
+
+func TestExpiredBuilds(t *testing.T) {
+	now := time.Now()
+	builds := []appBuild{
+		{sha: "aaaaaaaa", modTime: now.Add(-1 * time.Hour)},
+		{sha: "bbbbbbbb", modTime: now.Add(-48 * time.Hour)},
+		{sha: "cccccccc", modTime: now.Add(-72 * time.Hour)},
+		{sha: "dddddddd", modTime: now.Add(-96 * time.Hour)},
+	}
+	releaseShas := map[string]struct{}{"dddddddd": {}}
+
+	expired := expiredBuilds(builds, RetentionPolicy{KeepLast: 2}, releaseShas, now)
+	assert.Equal(t, len(expired), 1, "number of expired builds")
+	assert.Equal(t, expired[0].sha, "cccccccc", "expired build")
+
+	expired = expiredBuilds(builds, RetentionPolicy{MaxAge: 24 * time.Hour}, releaseShas, now)
+	assert.Equal(t, len(expired), 2, "number of expired builds")
+	assert.Equal(t, expired[0].sha, "bbbbbbbb", "expired build")
+
+	expired = expiredBuilds(builds, RetentionPolicy{KeepLast: 1, MaxAge: 50 * time.Hour}, releaseShas, now)
+	assert.Equal(t, len(expired), 1, "number of expired builds")
+	assert.Equal(t, expired[0].sha, "cccccccc", "expired build")
+}
+
+func TestUsedByRelease(t *testing.T) {
+	releaseShas := map[string]struct{}{"c3b4e4ba": {}, "0462cef5812ce31fe12f25596ff68dc614c708af": {}}
+	assert.True(t, usedByRelease("c3b4e4ba5d1a0b0c0d0e0f000102030405060708", releaseShas), "full sha of a short release sha")
+	assert.True(t, usedByRelease("0462cef5", releaseShas), "short sha of a full release sha")
+	assert.False(t, usedByRelease("deadbeef", releaseShas), "unreleased sha")
+}
+
+func TestEnforceRetentionPolicy(t *testing.T) {
+	storageDriver, err := factory.Create("inmemory", nil)
+	assert.NoErr(t, err)
+	keys := []string{
+		"/home/myapp:git-aaaaaaaa/tar",
+		"/home/myapp:git-bbbbbbbb/tar",
+		"/home/myapp:git-cccccccc/tar",
+		"/home/otherapp:git-dddddddd/tar",
+	}
+	for _, key := range keys {
+		assert.NoErr(t, storageDriver.PutContent(context.Background(), key, []byte("tar")))
+		// builds are sorted by modification time
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the oldest build is released, otherapp has no recorded release and is left alone
+	recordReleases(t, storageDriver, "myapp", "v2 aaaaaaaa5d1a0b0c0d0e0f000102030405060708\n")
+	assert.NoErr(t, enforceRetentionPolicy(storageDriver, nil, RetentionPolicy{KeepLast: 1}, time.Now()))
+
+	for _, key := range []string{keys[0], keys[2], keys[3]} {
+		_, err = storageDriver.Stat(context.Background(), key)
+		assert.NoErr(t, err)
+	}
+	_, err = storageDriver.Stat(context.Background(), keys[1])
+	assert.True(t, err != nil, "expired build was not deleted")
+}
+
+func TestReleaseRefs(t *testing.T) {
+	storageDriver, err := factory.Create("inmemory", nil)
+	assert.NoErr(t, err)
+	recordReleases(t, storageDriver, "myapp", "v3 bbbbbbbb5d1a0b0c0d0e0f000102030405060708\nv2 aaaaaaaa5d1a0b0c0d0e0f000102030405060708\n")
+	recordReleases(t, storageDriver, "badapp", "not a release\n")
+
+	images, shas, err := releaseRefs(storageDriver, "myapp")
+	assert.NoErr(t, err)
+	assert.Equal(t, images, []string{"myapp:v3", "myapp:v2"}, "release images")
+	assert.Equal(t, shas, map[string]struct{}{
+		"bbbbbbbb5d1a0b0c0d0e0f000102030405060708": {},
+		"aaaaaaaa5d1a0b0c0d0e0f000102030405060708": {},
+	}, "release shas")
+
+	_, _, err = releaseRefs(storageDriver, "newapp")
+	assert.True(t, err != nil, "no error returned without recorded release")
+	_, _, err = releaseRefs(storageDriver, "badapp")
+	assert.True(t, err != nil, "no error returned for a malformed record")
+}
+
+func TestRegistryClientDeleteImage(t *testing.T) {
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "HEAD" && strings.HasSuffix(r.URL.Path, "/manifests/git-aaaaaaaa"):
+			w.Header().Set("Docker-Content-Digest", "sha256:aaa")
+		case r.Method == "HEAD" && strings.HasSuffix(r.URL.Path, "/manifests/git-bbbbbbbb"):
+			w.Header().Set("Docker-Content-Digest", "sha256:bbb")
+		case r.Method == "HEAD" && strings.HasSuffix(r.URL.Path, "/manifests/v3"):
+			w.Header().Set("Docker-Content-Digest", "sha256:aaa")
+		case r.Method == "DELETE":
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	registry := &registryClient{client: http.DefaultClient, baseURL: srv.URL}
+	keep, err := registry.releaseDigests("myapp", []string{"127.0.0.1:5555/myapp:v3", "quay.io/deis/slugrunner:v2"})
+	assert.NoErr(t, err)
+	assert.Equal(t, keep, map[string]struct{}{"sha256:aaa": {}}, "release digests")
+
+	assert.NoErr(t, registry.deleteImage("myapp", "git-aaaaaaaa", keep))
+	assert.NoErr(t, registry.deleteImage("myapp", "git-bbbbbbbb", keep))
+	assert.NoErr(t, registry.deleteImage("myapp", "git-cccccccc", keep))
+	assert.Equal(t, deleted, []string{"/v2/myapp/manifests/sha256:bbb"}, "deleted manifests")
+}
@@ -3,16 +3,25 @@ package conf
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"strings"
 
 	"github.com/deis/builder/pkg/sys"
 )
 
+// StorageCredLocation is the path of the object storage secret.
+const StorageCredLocation = "/var/run/secrets/deis/objectstore/creds/"
+
+// FilesystemStorage is the storage type storing objects on a local volume, for single node and
+// development clusters.
+const FilesystemStorage = "filesystem"
+
 const (
-	storageCredLocation = "/var/run/secrets/deis/objectstore/creds/"
-	minioHostEnvVar     = "DEIS_MINIO_SERVICE_HOST"
-	minioPortEnvVar     = "DEIS_MINIO_SERVICE_PORT"
-	gcsKey              = "key.json"
+	minioHostEnvVar      = "DEIS_MINIO_SERVICE_HOST"
+	minioPortEnvVar      = "DEIS_MINIO_SERVICE_PORT"
+	storageRootDirEnvVar = "BUILDER_STORAGE_ROOT_DIRECTORY"
+	defaultStorageRoot   = "/var/lib/deis/builder/storage"
+	gcsKey               = "key.json"
 )
 
 // BuilderKeyLocation holds the path of the builder key secret.
@@ -33,9 +42,22 @@ func GetBuilderKey() (string, error) {
 
 // GetStorageParams returns the credentials required for connecting to object storage
 func GetStorageParams(env sys.Env) (Parameters, error) {
+	return GetStorageParamsFrom(env, env.Get("BUILDER_STORAGE"), StorageCredLocation)
+}
+
+// GetStorageParamsFrom returns the credentials required for connecting to object storage of type
+// storageType, read from credLocation, a folder laid out like the object storage secret. The
+// credentials are optional for the filesystem storage type, whose objects are stored under
+// BUILDER_STORAGE_ROOT_DIRECTORY unless credLocation has a rootdirectory file.
+func GetStorageParamsFrom(env sys.Env, storageType, credLocation string) (Parameters, error) {
 	params := make(map[string]interface{})
-	files, err := ioutil.ReadDir(storageCredLocation)
-	if err != nil {
+	if !strings.HasSuffix(credLocation, "/") {
+		credLocation += "/"
+	}
+	files, err := ioutil.ReadDir(credLocation)
+	if os.IsNotExist(err) && storageType == FilesystemStorage {
+		files = nil
+	} else if err != nil {
 		return nil, err
 	}
 
@@ -43,20 +65,20 @@ func GetStorageParams(env sys.Env) (Parameters, error) {
 		if file.IsDir() || file.Name() == "..data" {
 			continue
 		}
-		data, err := ioutil.ReadFile(storageCredLocation + file.Name())
+		data, err := ioutil.ReadFile(credLocation + file.Name())
 		if err != nil {
 			return nil, err
 		}
 		//GCS expect the to have the location of the service account credential json file
 		if file.Name() == gcsKey {
-			params["keyfile"] = storageCredLocation + file.Name()
+			params["keyfile"] = credLocation + file.Name()
 		} else {
 			params[file.Name()] = string(data)
 		}
 	}
 	params["bucket"] = params["builder-bucket"]
 	params["container"] = params["builder-container"]
-	if env.Get("BUILDER_STORAGE") == "minio" {
+	if storageType == "minio" {
 		mHost := env.Get(minioHostEnvVar)
 		mPort := env.Get(minioPortEnvVar)
 		params["regionendpoint"] = fmt.Sprintf("http://%s:%s", mHost, mPort)
@@ -64,6 +86,19 @@ func GetStorageParams(env sys.Env) (Parameters, error) {
 		params["region"] = "us-east-1"
 		params["bucket"] = "git"
 	}
+	if storageType == FilesystemStorage {
+		if _, ok := params["rootdirectory"]; !ok {
+			params["rootdirectory"] = FilesystemStorageRoot(env)
+		}
+	}
 
 	return params, nil
 }
+
+// FilesystemStorageRoot returns the folder holding the objects of the filesystem storage type.
+func FilesystemStorageRoot(env sys.Env) string {
+	if root := env.Get(storageRootDirEnvVar); root != "" {
+		return root
+	}
+	return defaultStorageRoot
+}
@@ -23,13 +23,13 @@ func TestGetStorageParams(t *testing.T) {
 		t.SkipNow()
 	}
 
-	if err := os.MkdirAll(storageCredLocation, os.ModeDir); err != nil {
+	if err := os.MkdirAll(StorageCredLocation, os.ModeDir); err != nil {
 		t.Fatalf("could not create storage cred location: %v", err)
 	}
 
-	// start by writing out a file to storageCredLocation
+	// start by writing out a file to StorageCredLocation
 	data := []byte("hello world\n")
-	if err := ioutil.WriteFile(storageCredLocation+"foo", data, 0644); err != nil {
+	if err := ioutil.WriteFile(StorageCredLocation+"foo", data, 0644); err != nil {
 		t.Fatalf("could not write file to storage cred location: %v", err)
 	}
 
@@ -47,8 +47,8 @@ func TestGetStorageParams(t *testing.T) {
 	}
 
 	// create a directory inside storage cred location, expecting it to pass
-	if err := os.Mkdir(storageCredLocation+"bar", os.ModeDir); err != nil {
-		t.Fatalf("could not create dir %s: %v", storageCredLocation+"bar", err)
+	if err := os.Mkdir(StorageCredLocation+"bar", os.ModeDir); err != nil {
+		t.Fatalf("could not create dir %s: %v", StorageCredLocation+"bar", err)
 	}
 
 	_, err = GetStorageParams(sys.NewFakeEnv())
@@ -57,8 +57,8 @@ func TestGetStorageParams(t *testing.T) {
 	}
 
 	// create the special "..data" directory symlink, expecting it to pass
-	if err := os.Symlink(storageCredLocation+"bar", storageCredLocation+"..data"); err != nil {
-		t.Fatalf("could not create dir symlink ..data -> %s: %v", storageCredLocation+"bar", err)
+	if err := os.Symlink(StorageCredLocation+"bar", StorageCredLocation+"..data"); err != nil {
+		t.Fatalf("could not create dir symlink ..data -> %s: %v", StorageCredLocation+"bar", err)
 	}
 
 	_, err = GetStorageParams(sys.NewFakeEnv())
@@ -82,6 +82,47 @@ func TestGetStorageParams(t *testing.T) {
 	assert.Equal(t, params["bucket"], "git", "bucket")
 }
 
+func TestGetStorageParamsFrom(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "creds")
+	if err != nil {
+		t.Fatalf("error creating temp directory (%s)", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "builder-bucket"), []byte("builder"), 0644); err != nil {
+		t.Fatalf("could not write file to %s: %v", tmpDir, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, gcsKey), []byte("{}"), 0644); err != nil {
+		t.Fatalf("could not write file to %s: %v", tmpDir, err)
+	}
+
+	params, err := GetStorageParamsFrom(sys.NewFakeEnv(), "gcs", tmpDir)
+	assert.NoErr(t, err)
+	assert.Equal(t, params["bucket"], "builder", "bucket")
+	assert.Equal(t, params["keyfile"], filepath.Join(tmpDir, gcsKey), "keyfile")
+}
+
+func TestGetStorageParamsFilesystem(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "creds")
+	if err != nil {
+		t.Fatalf("error creating temp directory (%s)", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	missing := filepath.Join(tmpDir, "missing")
+
+	_, err = GetStorageParamsFrom(sys.NewFakeEnv(), "s3", missing)
+	assert.True(t, err != nil, "no error received for missing s3 credentials")
+
+	params, err := GetStorageParamsFrom(sys.NewFakeEnv(), FilesystemStorage, missing)
+	assert.NoErr(t, err)
+	assert.Equal(t, params["rootdirectory"], defaultStorageRoot, "root directory")
+
+	env := sys.NewFakeEnv()
+	env.Envs = map[string]string{"BUILDER_STORAGE_ROOT_DIRECTORY": "/data"}
+	params, err = GetStorageParamsFrom(env, FilesystemStorage, missing)
+	assert.NoErr(t, err)
+	assert.Equal(t, params["rootdirectory"], "/data", "root directory")
+}
+
 func TestGetControllerClient(t *testing.T) {
 	tmpDir, err := ioutil.TempDir("", "tmpdir")
 	if err != nil {
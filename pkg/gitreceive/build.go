@@ -10,7 +10,9 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
+	builderconf "github.com/deis/builder/pkg/conf"
 	"github.com/deis/builder/pkg/controller"
 	"github.com/deis/builder/pkg/git"
 	"github.com/deis/builder/pkg/k8s"
@@ -19,7 +21,6 @@ import (
 	deisAPI "github.com/deis/controller-sdk-go/api"
 	"github.com/deis/controller-sdk-go/hooks"
 	"github.com/deis/pkg/log"
-	"github.com/docker/distribution/context"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
 	"gopkg.in/yaml.v2"
 	"k8s.io/kubernetes/pkg/api"
@@ -52,7 +53,10 @@ func build(
 	fs sys.FS,
 	env sys.Env,
 	builderKey,
-	rawGitSha string) error {
+	rawGitSha,
+	refName string) (err error) {
+
+	started := time.Now()
 
 	dockerBuilderImagePullPolicy, err := k8s.PullPolicyFromString(conf.DockerBuilderImagePullPolicy)
 	if err != nil {
@@ -70,12 +74,30 @@ func build(
 		return err
 	}
 
+	sha, err := buildSha(conf.ObjectKeyLayout, gitSha)
+	if err != nil {
+		return err
+	}
+
 	appName := conf.App()
 
+	// record everything shown to the user so that it can be retrieved after the build pod is gone
+	buildLog := newBuildLog()
+	log.DefaultLogger.SetStdout(io.MultiWriter(os.Stdout, buildLog))
+	defer func() {
+		log.DefaultLogger.SetStdout(os.Stdout)
+		if err != nil {
+			fmt.Fprintf(buildLog, "Error: %s\n", err)
+		}
+		if err := buildLog.persist(storageDriver, BuildLogKey(appName, sha)); err != nil {
+			log.Info("unable to persist the build log (%s)", err)
+		}
+	}()
+
 	repoDir := filepath.Join(conf.GitHome, repo)
 	buildDir := filepath.Join(repoDir, "build")
 
-	slugName := fmt.Sprintf("%s:git-%s", appName, gitSha.Short())
+	slugName := fmt.Sprintf("%s:git-%s", appName, sha)
 	if err := os.MkdirAll(buildDir, os.ModeDir); err != nil {
 		return fmt.Errorf("making the build directory %s (%s)", buildDir, err)
 	}
@@ -101,7 +123,13 @@ func build(
 		return err
 	}
 
-	log.Debug("got the following config back for app %s: %+v", appName, appConf)
+	redactor, err := newRedactor(conf.DebugRedactPatterns)
+	if err != nil {
+		return err
+	}
+	redactor.addBuildSecrets(appConf.Values)
+
+	log.Debug("got the following config back for app %s: %+v", appName, redactor.env(appConf.Values))
 	var buildPackURL string
 	if buildPackURLInterface, ok := appConf.Values["BUILDPACK_URL"]; ok {
 		if bpStr, ok := buildPackURLInterface.(string); ok {
@@ -110,23 +138,50 @@ func build(
 		}
 	}
 
+	sourceDir, err := getSourceDir(appConf.Values)
+	if err != nil {
+		return err
+	}
+	if _, err := newDockerBuildOptions(appConf.Values); err != nil {
+		return err
+	}
+	buildPaths, err := getBuildPaths(appConf.Values, sourceDir)
+	if err != nil {
+		return err
+	}
+	touched := true
+	if len(buildPaths) > 0 {
+		// compare with the last build rather than with the previous push, which may have failed
+		if lastSha, err := lastBuiltSha(storageDriver, appName); err != nil {
+			log.Info("Unable to get the last build of %s, building anyway (%s)", appName, err)
+		} else if touched, err = pushTouchesPaths(repoDir, lastSha, gitSha.Full(), buildPaths); err != nil {
+			return err
+		}
+	}
+	if !touched {
+		log.Info("No changes in %s, skipping the build.", strings.Join(buildPaths, ", "))
+		return nil
+	}
+
 	_, disableCaching := appConf.Values["DEIS_DISABLE_CACHE"]
-	slugBuilderInfo := NewSlugBuilderInfo(appName, gitSha.Short(), disableCaching)
+	slugBuilderInfo := NewSlugBuilderInfo(appName, sha, refName, disableCaching)
 
 	if slugBuilderInfo.DisableCaching() {
 		log.Debug("caching disabled for app %s", appName)
-		// If cache file exists, delete it
-		if _, err := storageDriver.Stat(context.Background(), slugBuilderInfo.CacheKey()); err == nil {
-			log.Debug("deleting cache %s for app %s", slugBuilderInfo.CacheKey(), appName)
-			if err := storageDriver.Delete(context.Background(), slugBuilderInfo.CacheKey()); err != nil {
-				return err
-			}
+		// If cache files exist, delete them
+		if err := PurgeCache(storageDriver, appName); err != nil {
+			return err
 		}
 	}
 
 	// build a tarball from the new objects
 	appTgz := fmt.Sprintf("%s.tar.gz", appName)
-	gitArchiveCmd := repoCmd(repoDir, "git", "archive", "--format=tar.gz", fmt.Sprintf("--output=%s", appTgz), gitSha.Short())
+	treeish := gitSha.Full()
+	if sourceDir != "" {
+		// archive only the subtree, with its contents at the root of the tarball
+		treeish = fmt.Sprintf("%s:%s", gitSha.Full(), sourceDir)
+	}
+	gitArchiveCmd := repoCmd(repoDir, "git", "archive", "--format=tar.gz", fmt.Sprintf("--output=%s", appTgz), treeish)
 	gitArchiveCmd.Stdout = os.Stdout
 	gitArchiveCmd.Stderr = os.Stderr
 	if err := run(gitArchiveCmd); err != nil {
@@ -142,100 +197,163 @@ func build(
 		return fmt.Errorf("running %s (%s)", strings.Join(tarCmd.Args, " "), err)
 	}
 
-	bType := getBuildTypeForDir(tmpDir)
-	usingDockerfile := bType == buildTypeDockerfile
-
-	appTgzdata, err := ioutil.ReadFile(absAppTgz)
+	strategy, reason, err := selectBuildStrategy(tmpDir, appConf.Values)
 	if err != nil {
-		return fmt.Errorf("error while reading file %s: (%s)", appTgz, err)
+		return err
 	}
+	log.Info("Using the %s build strategy (%s)", strategy.name, reason)
 
-	log.Debug("Uploading tar to %s", slugBuilderInfo.TarKey())
+	builderPodNodeSelector, err := buildBuilderPodNodeSelector(conf.BuilderPodNodeSelector)
+	if err != nil {
+		return fmt.Errorf("error build builder pod node selector %s", err)
+	}
 
-	if err := storageDriver.PutContent(context.Background(), slugBuilderInfo.TarKey(), appTgzdata); err != nil {
-		return fmt.Errorf("uploading %s to %s (%v)", absAppTgz, slugBuilderInfo.TarKey(), err)
+	builderPodOptions, err := newBuilderPodOptions(conf, appConf.Values)
+	if err != nil {
+		return err
 	}
 
-	var pod *api.Pod
-	var buildPodName string
-	image := appName
+	podTemplate, err := loadPodTemplate(conf.BuilderPodTemplatePath)
+	if err != nil {
+		return err
+	}
 
-	builderPodNodeSelector, err := buildBuilderPodNodeSelector(conf.BuilderPodNodeSelector)
+	b := &buildContext{
+		conf:                         conf,
+		kubeClient:                   kubeClient,
+		storage:                      storageDriver,
+		dir:                          tmpDir,
+		appName:                      appName,
+		appValues:                    appConf.Values,
+		gitSha:                       gitSha,
+		sha:                          sha,
+		slugName:                     slugName,
+		slugBuilderInfo:              slugBuilderInfo,
+		buildPackURL:                 buildPackURL,
+		dockerBuilderImagePullPolicy: dockerBuilderImagePullPolicy,
+		slugBuilderImagePullPolicy:   slugBuilderImagePullPolicy,
+		nodeSelector:                 builderPodNodeSelector,
+		template:                     podTemplate,
+		redactor:                     redactor,
+	}
+	pod, image, cleanup, err := strategy.pod(b)
 	if err != nil {
-		return fmt.Errorf("error build builder pod node selector %s", err)
+		return err
 	}
+	if cleanup != nil {
+		defer cleanup()
+	}
+	manifest := &buildManifest{
+		App:      appName,
+		Strategy: strategy.name.String(),
+		Image:    image,
+		Source:   buildManifestSource{SHA: gitSha.Full(), Ref: refName},
+		Pusher:   buildManifestPusher{Username: conf.Username, Fingerprint: conf.Fingerprint},
+		Builder:  buildManifestBuilder{BuildpackURL: buildPackURL},
+		Timings:  buildManifestTimings{Started: started},
+	}
+	// strategies deploying a prebuilt image have no builder pod to run
+	if pod != nil {
+		if conf.EncryptionKeyID != "" && !encryptionSupported(conf, pod) {
+			return fmt.Errorf("%s is not listed in BUILDER_ENCRYPTION_PODS, it can't decrypt the encrypted source tarball", pod.Spec.Containers[0].Name)
+		}
+		appTgzdata, err := ioutil.ReadFile(absAppTgz)
+		if err != nil {
+			return fmt.Errorf("error while reading file %s: (%s)", appTgz, err)
+		}
 
-	if usingDockerfile {
-		buildPodName = dockerBuilderPodName(appName, gitSha.Short())
-		registryLocation := conf.RegistryLocation
-		registryEnv := make(map[string]string)
-		if registryLocation != "on-cluster" {
-			registryEnv, err = getRegistryDetails(kubeClient, &image, registryLocation, conf.PodNamespace, conf.RegistrySecretPrefix)
-			if err != nil {
-				return fmt.Errorf("error getting private registry details %s", err)
-			}
-			image = image + ":git-" + gitSha.Short()
+		log.Debug("Uploading tar to %s", slugBuilderInfo.TarKey())
+
+		tarChecksum, err := storage.PutContentWithChecksum(storageDriver, slugBuilderInfo.TarKey(), appTgzdata)
+		if err != nil {
+			return fmt.Errorf("uploading %s to %s (%v)", absAppTgz, slugBuilderInfo.TarKey(), err)
 		}
-		registryEnv["DEIS_REGISTRY_PROXY_PORT"] = conf.RegistryProxyPort
-		registryEnv["DEIS_REGISTRY_LOCATION"] = registryLocation
-
-		pod = dockerBuilderPod(
-			conf.Debug,
-			buildPodName,
-			conf.PodNamespace,
-			appConf.Values,
-			slugBuilderInfo.TarKey(),
-			gitSha.Short(),
-			slugName,
-			conf.StorageType,
-			conf.DockerBuilderImage,
-			conf.RegistryHost,
-			conf.RegistryPort,
-			registryEnv,
-			dockerBuilderImagePullPolicy,
-			builderPodNodeSelector,
-		)
-	} else {
-		buildPodName = slugBuilderPodName(appName, gitSha.Short())
 
-		cacheKey := ""
-		if !slugBuilderInfo.DisableCaching() {
-			cacheKey = slugBuilderInfo.CacheKey()
+		builderPodOptions.apply(pod)
+		// builder pods validate the tarball they download against its checksum
+		addEnvToPod(*pod, tarChecksumEnv, tarChecksum)
+		if conf.StorageType == builderconf.FilesystemStorage {
+			mountFilesystemStorage(pod, conf.StorageClaimName, conf.StorageRootDirectory)
 		}
-		envSecretName := fmt.Sprintf("%s-build-env", appName)
-		err = createAppEnvConfigSecret(kubeClient.Secrets(conf.PodNamespace), envSecretName, appConf.Values)
-		if err != nil {
-			return fmt.Errorf("error creating/updating secret %s: (%s)", envSecretName, err)
+		if conf.EncryptionKeyID != "" {
+			mountEncryptionKeys(pod, conf.EncryptionKeysSecret, conf.EncryptionKeyID)
+		}
+		manifest.Builder.Image = pod.Spec.Containers[0].Image
+		builderStarted := time.Now()
+		manifest.Timings.BuilderStarted = &builderStarted
+		if err := runBuilderPod(conf, kubeClient, buildLog, redactor, pod); err != nil {
+			return err
 		}
-		defer func() {
-			if err := kubeClient.Secrets(conf.PodNamespace).Delete(envSecretName); err != nil {
-				log.Info("unable to delete secret %s (%s)", envSecretName, err)
+		builderFinished := time.Now()
+		manifest.Timings.BuilderFinished = &builderFinished
+		if strategy.name == buildTypeProcfile && !slugBuilderInfo.DisableCaching() {
+			if err := recordBuildpackCacheSize(storageDriver, slugBuilderInfo.CacheKey()); err != nil {
+				log.Info("Unable to update the build cache metadata (%s)", err)
 			}
-		}()
-		pod = slugbuilderPod(
-			conf.Debug,
-			buildPodName,
-			conf.PodNamespace,
-			envSecretName,
-			slugBuilderInfo.TarKey(),
-			slugBuilderInfo.PushKey(),
-			cacheKey,
-			gitSha.Short(),
-			buildPackURL,
-			conf.StorageType,
-			conf.SlugBuilderImage,
-			slugBuilderImagePullPolicy,
-			builderPodNodeSelector,
-		)
+		}
+	}
+	if len(b.mirrors) > 0 {
+		reportPushes(storageDriver, pushResultsKey(slugBuilderInfo.PushKey()), image, b.mirrors)
 	}
 
+	procType, err := strategy.procTypes(b, storageDriver, tmpDir)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Build complete.")
+
+	digest, err := strategy.digest(b, image)
+	if err != nil {
+		log.Info("Unable to get the digest of %s (%s)", image, err)
+	}
+	manifest.Digest = digest
+	manifest.Timings.Finished = time.Now()
+	if err := manifest.persist(storageDriver, buildManifestKey(slugBuilderInfo.PushKey())); err != nil {
+		log.Info("Unable to persist the build manifest (%s)", err)
+	}
+
+	quit := progress("...", conf.SessionIdleInterval())
+	log.Info("Launching App...")
+	release, err := hooks.CreateBuild(client, conf.Username, conf.App(), image, sha, procType, strategy.releasesImage)
+	quit <- true
+	<-quit
+	if controller.CheckAPICompat(client, err) != nil {
+		return fmt.Errorf("The controller returned an error when publishing the release: %s", err)
+	}
+
+	if err := recordLastBuiltSha(storageDriver, appName, gitSha.Full()); err != nil {
+		log.Info("Unable to record the last build of %s (%s)", appName, err)
+	}
+	if err := recordRelease(storageDriver, appName, release, gitSha.Full()); err != nil {
+		log.Info("Unable to record release v%d of %s, the cleaner may not keep its build (%s)", release, appName, err)
+	}
+
+	log.Info("Done, %s:v%d deployed to Workflow\n", appName, release)
+	log.Info("Use 'deis open' to view this application in your browser\n")
+	log.Info("To learn more, use 'deis help' or visit https://deis.com/\n")
+	log.Info("Build manifest:\n%s\n", manifest)
+
+	run(repoCmd(repoDir, "git", "gc"))
+
+	return nil
+}
+
+// runBuilderPod creates pod and streams its logs to stdout and buildLog until it terminates. It
+// returns an error if the pod didn't succeed.
+func runBuilderPod(conf *Config, kubeClient *client.Client, buildLog io.Writer, redactor *redactor, pod *api.Pod) error {
 	log.Info("Starting build... but first, coffee!")
-	log.Debug("Starting pod %s", buildPodName)
-	json, err := prettyPrintJSON(pod)
+	log.Debug("Starting pod %s", pod.Name)
+	redacted, err := redactor.pod(pod)
 	if err == nil {
-		log.Debug("Pod spec: %v", json)
+		json, err := prettyPrintJSON(redacted)
+		if err == nil {
+			log.Debug("Pod spec: %v", json)
+		} else {
+			log.Debug("Error creating json representation of pod spec: %v", err)
+		}
 	} else {
-		log.Debug("Error creating json representation of pod spec: %v", err)
+		log.Debug("Error redacting pod spec: %v", err)
 	}
 
 	podsInterface := kubeClient.Pods(conf.PodNamespace)
@@ -250,22 +368,18 @@ func build(
 	defer close(stopCh)
 	go pw.Controller.Run(stopCh)
 
-	if err := waitForPod(pw, newPod.Namespace, newPod.Name, conf.SessionIdleInterval(), conf.BuilderPodTickDuration(), conf.BuilderPodWaitDuration()); err != nil {
+	events := kubePodEventLister(kubeClient, newPod.Namespace, newPod.Name)
+	if err := waitForPod(pw, newPod.Namespace, newPod.Name, events, conf.SessionIdleInterval(), conf.BuilderPodTickDuration(), conf.BuilderPodWaitDuration(), conf.BuilderPodScheduleTimeout()); err != nil {
 		return fmt.Errorf("watching events for builder pod startup (%s)", err)
 	}
 
-	req := kubeClient.Get().Namespace(newPod.Namespace).Name(newPod.Name).Resource("pods").SubResource("log").VersionedParams(
-		&api.PodLogOptions{
-			Follow: true,
-		}, api.ParameterCodec)
-
-	rc, err := req.Stream()
-	if err != nil {
-		return fmt.Errorf("attempting to stream logs (%s)", err)
-	}
-	defer rc.Close()
-
-	size, err := io.Copy(os.Stdout, rc)
+	size, err := followPodLogs(
+		io.MultiWriter(os.Stdout, buildLog),
+		kubePodLogStreamer(kubeClient, newPod.Namespace, newPod.Name, pod.Spec.Containers[0].Name),
+		kubePodTerminatedChecker(kubeClient, newPod.Namespace, newPod.Name, pod.Spec.Containers[0].Name),
+		conf.BuilderPodLogMaxReconnects,
+		conf.SessionIdleInterval(),
+	)
 	if err != nil {
 		return fmt.Errorf("fetching builder logs (%s)", err)
 	}
@@ -280,7 +394,7 @@ func build(
 	)
 	// check the state and exit code of the build pod.
 	// if the code is not 0 return error
-	if err := waitForPodEnd(pw, newPod.Namespace, newPod.Name, conf.BuilderPodTickDuration(), conf.BuilderPodWaitDuration()); err != nil {
+	if err := waitForPodEnd(pw, newPod.Namespace, newPod.Name, pod.Spec.Containers[0].Name, conf.BuilderPodTickDuration(), conf.BuilderPodWaitDuration()); err != nil {
 		return fmt.Errorf("error getting builder pod status (%s)", err)
 	}
 	log.Debug("Done")
@@ -290,39 +404,16 @@ func build(
 		return fmt.Errorf("error getting builder pod status (%s)", err)
 	}
 
-	for _, containerStatus := range buildPod.Status.ContainerStatuses {
-		state := containerStatus.State.Terminated
-		if state.ExitCode != 0 {
-			return fmt.Errorf("Build pod exited with code %d, stopping build.", state.ExitCode)
+	// sidecars from the pod template may keep running once the builder container terminated
+	if buildPod.Status.Phase == api.PodRunning {
+		if err := podsInterface.Delete(buildPod.Name, nil); err != nil {
+			log.Debug("deleting builder pod %s left running by its sidecars (%s)", buildPod.Name, err)
 		}
 	}
-	log.Debug("Done")
-
-	procType, err := getProcFile(storageDriver, tmpDir, slugBuilderInfo.AbsoluteProcfileKey(), bType)
-	if err != nil {
+	if err := podTerminationError(buildPod); err != nil {
 		return err
 	}
-
-	log.Info("Build complete.")
-
-	quit := progress("...", conf.SessionIdleInterval())
-	log.Info("Launching App...")
-	if !usingDockerfile {
-		image = slugBuilderInfo.AbsoluteSlugObjectKey()
-	}
-	release, err := hooks.CreateBuild(client, conf.Username, conf.App(), image, gitSha.Short(), procType, usingDockerfile)
-	quit <- true
-	<-quit
-	if controller.CheckAPICompat(client, err) != nil {
-		return fmt.Errorf("The controller returned an error when publishing the release: %s", err)
-	}
-
-	log.Info("Done, %s:v%d deployed to Workflow\n", appName, release)
-	log.Info("Use 'deis open' to view this application in your browser\n")
-	log.Info("To learn more, use 'deis help' or visit https://deis.com/\n")
-
-	run(repoCmd(repoDir, "git", "gc"))
-
+	log.Debug("Done")
 	return nil
 }
 
@@ -368,8 +459,10 @@ func getProcFile(getter storage.ObjectGetter, dirName, procfileKey string, bType
 		return procType, nil
 	}
 	log.Debug("Procfile not present. Getting it from the buildpack")
-	rawProcFile, err := getter.GetContent(context.Background(), procfileKey)
-	if err != nil {
+	rawProcFile, err := storage.GetContentWithChecksum(getter, procfileKey)
+	if _, ok := err.(storage.CorruptedObjectError); ok {
+		return nil, err
+	} else if err != nil {
 		return nil, fmt.Errorf("error in reading %s (%s)", procfileKey, err)
 	}
 	if err := yaml.Unmarshal(rawProcFile, &procType); err != nil {
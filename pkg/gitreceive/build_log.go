@@ -0,0 +1,103 @@
+package gitreceive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/deis/builder/pkg/storage"
+)
+
+const (
+	buildLogName      = "log.gz"
+	buildLogTimestamp = time.RFC3339
+)
+
+// BuildLogKey returns the object storage key of the compressed build log for the given app and
+// git sha, full or short depending on the object key layout the build was stored with.
+func BuildLogKey(appName, sha string) string {
+	return fmt.Sprintf(GitKeyPattern, appName, sha) + "/" + buildLogName
+}
+
+// buildLog is an io.Writer that records everything shown to the user during a build, prefixing
+// every line with the time at which it was written. It's safe for concurrent use.
+type buildLog struct {
+	mut     sync.Mutex
+	buf     bytes.Buffer
+	now     func() time.Time
+	midLine bool
+}
+
+func newBuildLog() *buildLog {
+	return &buildLog{now: time.Now}
+}
+
+// Write is the io.Writer interface implementation.
+func (b *buildLog) Write(p []byte) (int, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if !b.midLine {
+			b.buf.WriteString(b.now().UTC().Format(buildLogTimestamp))
+			b.buf.WriteByte(' ')
+		}
+		b.buf.Write(line)
+		b.midLine = line[len(line)-1] != '\n'
+	}
+	return len(p), nil
+}
+
+// Bytes returns the uncompressed, timestamped contents of the log.
+func (b *buildLog) Bytes() []byte {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return append([]byte{}, b.buf.Bytes()...)
+}
+
+// persist compresses the log and writes it to key using putter.
+func (b *buildLog) persist(putter storage.ObjectPutter, key string) error {
+	compressed := new(bytes.Buffer)
+	gzw := gzip.NewWriter(compressed)
+	if _, err := gzw.Write(b.Bytes()); err != nil {
+		return err
+	}
+	if err := gzw.Close(); err != nil {
+		return err
+	}
+	if _, err := storage.PutContentWithChecksum(putter, key, compressed.Bytes()); err != nil {
+		return fmt.Errorf("uploading build log to %s (%s)", key, err)
+	}
+	return nil
+}
+
+// GetBuildLog fetches the build log for the given app and git sha from object storage and returns
+// it uncompressed. Given the full git sha, the log of builds stored with the legacy short sha layout
+// is found too.
+func GetBuildLog(getter storage.ObjectGetter, appName, sha string) ([]byte, error) {
+	var key string
+	var compressed []byte
+	var err error
+	for _, keySha := range buildKeyShas(sha) {
+		key = BuildLogKey(appName, keySha)
+		if compressed, err = storage.GetContentWithChecksum(getter, key); err == nil {
+			break
+		} else if _, ok := err.(storage.CorruptedObjectError); ok {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error in reading %s (%s)", key, err)
+	}
+	gzr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("build log %s is malformed (%s)", key, err)
+	}
+	defer gzr.Close()
+	return ioutil.ReadAll(gzr)
+}
@@ -0,0 +1,83 @@
+package gitreceive
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/arschles/assert"
+	"github.com/deis/builder/pkg/storage"
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+func TestBuildLogKey(t *testing.T) {
+	assert.Equal(t, BuildLogKey("myapp", "c3b4e4ba"), "home/myapp:git-c3b4e4ba/log.gz", "key")
+}
+
+func TestBuildLogWrite(t *testing.T) {
+	bl := newBuildLog()
+	bl.now = func() time.Time { return time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC) }
+	fmt.Fprint(bl, "-----> Fetching ")
+	fmt.Fprint(bl, "buildpack\n-----> Compiling\n")
+	fmt.Fprint(bl, "\n")
+	expected := "2016-01-02T15:04:05Z -----> Fetching buildpack\n" +
+		"2016-01-02T15:04:05Z -----> Compiling\n" +
+		"2016-01-02T15:04:05Z \n"
+	assert.Equal(t, string(bl.Bytes()), expected, "build log")
+}
+
+func TestBuildLogPersistAndGet(t *testing.T) {
+	bl := newBuildLog()
+	fmt.Fprintln(bl, "Build complete.")
+	putter := &storage.FakeObjectPutter{
+		Fn: func(context.Context, string, []byte) error {
+			return nil
+		},
+	}
+	assert.NoErr(t, bl.persist(putter, BuildLogKey("myapp", "c3b4e4ba")))
+	assert.Equal(t, len(putter.Calls), 2, "number of PutContent calls")
+	assert.Equal(t, putter.Calls[0].Path, "home/myapp:git-c3b4e4ba/log.gz", "object key")
+	assert.Equal(t, putter.Calls[1].Path, "home/myapp:git-c3b4e4ba/log.gz.sha256", "checksum key")
+
+	getter := &storage.FakeObjectGetter{
+		Fn: func(ctx context.Context, path string) ([]byte, error) {
+			for _, call := range putter.Calls {
+				if call.Path == path {
+					return call.Content, nil
+				}
+			}
+			return nil, storagedriver.PathNotFoundError{Path: path}
+		},
+	}
+	// the log of a build stored with the legacy short sha layout should be found from the full sha
+	contents, err := GetBuildLog(getter, "myapp", "c3b4e4ba5d1a0b0c0d0e0f000102030405060708")
+	assert.NoErr(t, err)
+	assert.Equal(t, contents, bl.Bytes(), "build log")
+	assert.Equal(t, len(getter.Calls), 3, "number of GetContent calls")
+	assert.Equal(t, getter.Calls[0].Path, "home/myapp:git-c3b4e4ba5d1a0b0c0d0e0f000102030405060708/log.gz", "object key")
+	assert.Equal(t, getter.Calls[1].Path, "home/myapp:git-c3b4e4ba/log.gz", "legacy object key")
+	assert.Equal(t, getter.Calls[2].Path, "home/myapp:git-c3b4e4ba/log.gz.sha256", "legacy checksum key")
+}
+
+func TestBuildLogPersistErr(t *testing.T) {
+	expectedErr := errors.New("test error")
+	putter := &storage.FakeObjectPutter{
+		Fn: func(context.Context, string, []byte) error {
+			return expectedErr
+		},
+	}
+	err := newBuildLog().persist(putter, "key")
+	assert.Err(t, err, fmt.Errorf("uploading build log to key (%s)", expectedErr))
+}
+
+func TestGetBuildLogMalformed(t *testing.T) {
+	getter := &storage.FakeObjectGetter{
+		Fn: func(context.Context, string) ([]byte, error) {
+			return []byte("not gzipped"), nil
+		},
+	}
+	_, err := GetBuildLog(getter, "myapp", "c3b4e4ba")
+	assert.True(t, err != nil, "no error received when there should have been")
+}
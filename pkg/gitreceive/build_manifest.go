@@ -0,0 +1,124 @@
+package gitreceive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/deis/builder/pkg/storage"
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+const buildManifestName = "build-manifest.json"
+
+// buildManifest records what a build produced and how, so that a release can be traced back to
+// the exact artifact and source it came from, even though the image name given to the controller
+// is a mutable tag.
+type buildManifest struct {
+	App      string `json:"app"`
+	Strategy string `json:"strategy"`
+	// Image is the image or slug given to the controller
+	Image string `json:"image"`
+	// Digest is the digest of the pushed image, or the sha256 of the slug
+	Digest  string               `json:"digest,omitempty"`
+	Source  buildManifestSource  `json:"source"`
+	Pusher  buildManifestPusher  `json:"pusher"`
+	Builder buildManifestBuilder `json:"builder"`
+	Timings buildManifestTimings `json:"timings"`
+}
+
+type buildManifestSource struct {
+	SHA string `json:"sha"`
+	Ref string `json:"ref,omitempty"`
+}
+
+type buildManifestPusher struct {
+	Username    string `json:"username"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type buildManifestBuilder struct {
+	// Image is the image of the builder pod, empty if the strategy needed none
+	Image        string `json:"image,omitempty"`
+	BuildpackURL string `json:"buildpackURL,omitempty"`
+}
+
+type buildManifestTimings struct {
+	Started         time.Time  `json:"started"`
+	BuilderStarted  *time.Time `json:"builderStarted,omitempty"`
+	BuilderFinished *time.Time `json:"builderFinished,omitempty"`
+	Finished        time.Time  `json:"finished"`
+}
+
+// buildManifestKey returns the object storage key of the build manifest, next to the slug.
+func buildManifestKey(pushKey string) string {
+	return pushKey + "/" + buildManifestName
+}
+
+// persist uploads the build manifest to key.
+func (m *buildManifest) persist(putter storage.ObjectPutter, key string) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = storage.PutContentWithChecksum(putter, key, raw)
+	return err
+}
+
+// String returns the build manifest as indented JSON, for printing at the end of the push.
+func (m *buildManifest) String() string {
+	raw, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%+v", *m)
+	}
+	return string(raw)
+}
+
+// slugDigest returns the digest of the slug of a procfile build, the sha256 of slug.tgz as reported
+// by its builder pod to SLUG_DIGEST_PATH. It's empty if the slugbuilder didn't report it.
+func slugDigest(b *buildContext, image string) (string, error) {
+	key := storage.ChecksumKey(b.slugBuilderInfo.AbsoluteSlugObjectKey())
+	raw, err := b.storage.GetContent(context.Background(), key)
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("error in reading %s (%s)", key, err)
+	}
+	sum := strings.TrimSpace(string(raw))
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != 2*sha256.Size {
+		return "", fmt.Errorf("slug digest %s is malformed", key)
+	}
+	return "sha256:" + sum, nil
+}
+
+// dockerfileDigest returns the digest of the image of a Dockerfile build, as reported by its
+// builder pod.
+func dockerfileDigest(b *buildContext, image string) (string, error) {
+	results, err := getPushResults(b.storage, pushResultsKey(b.slugBuilderInfo.PushKey()))
+	if err != nil {
+		return "", err
+	}
+	return results.Digest, nil
+}
+
+// cnbDigest returns the digest of the image of a CNB build, as reported by its builder pod.
+func cnbDigest(b *buildContext, image string) (string, error) {
+	metadata, err := getCNBMetadata(b.storage, cnbMetadataKey(b.slugBuilderInfo.PushKey()))
+	if err != nil {
+		return "", err
+	}
+	return metadata.Digest, nil
+}
+
+// imageReferenceDigest returns the digest image is pinned to, if any. Prebuilt images aren't
+// pushed by the builder, so their digest is only known if the deploy manifest names it.
+func imageReferenceDigest(b *buildContext, image string) (string, error) {
+	if idx := strings.Index(image, "@"); idx >= 0 {
+		return image[idx+1:], nil
+	}
+	return "", nil
+}
@@ -0,0 +1,69 @@
+package gitreceive
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/arschles/assert"
+	"github.com/deis/builder/pkg/storage"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+)
+
+func TestBuildManifestPersist(t *testing.T) {
+	manifest := &buildManifest{
+		App:      "myapp",
+		Strategy: buildTypeDockerfile.String(),
+		Image:    "myapp",
+		Digest:   "sha256:abc",
+		Source:   buildManifestSource{SHA: "c3b4e4ba8b0d3a7c9a5c1f8e2b9d0a6e4f3c2b1a", Ref: "refs/heads/master"},
+		Pusher:   buildManifestPusher{Username: "admin", Fingerprint: "aa:bb"},
+		Timings:  buildManifestTimings{Started: time.Now(), Finished: time.Now()},
+	}
+	putter := &storage.FakeObjectPutter{
+		Fn: func(context.Context, string, []byte) error {
+			return nil
+		},
+	}
+	key := buildManifestKey("home/myapp:git-c3b4e4ba/push")
+	assert.NoErr(t, manifest.persist(putter, key))
+	assert.Equal(t, len(putter.Calls), 2, "number of PutContent calls")
+	assert.Equal(t, putter.Calls[0].Path, "home/myapp:git-c3b4e4ba/push/build-manifest.json", "object key")
+	assert.Equal(t, putter.Calls[1].Path, "home/myapp:git-c3b4e4ba/push/build-manifest.json.sha256", "checksum key")
+
+	persisted := new(buildManifest)
+	assert.NoErr(t, json.Unmarshal(putter.Calls[0].Content, persisted))
+	assert.Equal(t, persisted.Digest, "sha256:abc", "digest")
+	assert.Equal(t, persisted.Source, manifest.Source, "source")
+	assert.True(t, persisted.Timings.BuilderStarted == nil, "builder start time set without builder pod")
+}
+
+func TestSlugDigest(t *testing.T) {
+	storageDriver, err := factory.Create("inmemory", nil)
+	assert.NoErr(t, err)
+	b := &buildContext{storage: storageDriver, slugBuilderInfo: NewSlugBuilderInfo("myapp", "c3b4e4ba", "", false)}
+	digest, err := slugDigest(b, "")
+	assert.NoErr(t, err)
+	assert.Equal(t, digest, "", "digest not reported by the slugbuilder")
+
+	key := "/home/myapp:git-c3b4e4ba/push/slug.tgz.sha256"
+	sum := "cd03861f0ff8922a279f1eab771f91f6336df9e7dd9412355ec6a1a79c244a5e"
+	assert.NoErr(t, storageDriver.PutContent(context.Background(), key, []byte(sum+"\n")))
+	digest, err = slugDigest(b, "")
+	assert.NoErr(t, err)
+	assert.Equal(t, digest, "sha256:"+sum, "digest")
+
+	assert.NoErr(t, storageDriver.PutContent(context.Background(), key, []byte("not a digest")))
+	_, err = slugDigest(b, "")
+	assert.True(t, err != nil, "no error returned for a malformed digest")
+}
+
+func TestImageReferenceDigest(t *testing.T) {
+	digest, err := imageReferenceDigest(nil, "quay.io/myorg/myapp@sha256:abc")
+	assert.NoErr(t, err)
+	assert.Equal(t, digest, "sha256:abc", "digest")
+	digest, err = imageReferenceDigest(nil, "quay.io/myorg/myapp:1.0")
+	assert.NoErr(t, err)
+	assert.Equal(t, digest, "", "digest")
+}
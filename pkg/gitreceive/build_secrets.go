@@ -0,0 +1,91 @@
+package gitreceive
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/deis/pkg/log"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	// appBuildSecretsKey lists, comma separated, the app config keys holding build secrets. Their
+	// values are only given to builder pods as files, never as env vars or docker build args.
+	appBuildSecretsKey = "DEIS_BUILD_SECRETS"
+
+	buildSecretsName    = "build-secrets"
+	buildSecretsPath    = "/var/run/secrets/deis/build"
+	buildSecretsDirEnv  = "BUILD_SECRETS_DIR"
+	buildSecretsListEnv = "BUILD_SECRETS"
+)
+
+// getBuildSecrets splits the app config into the build secrets it references and the rest of it.
+// It returns an error if a referenced secret isn't set.
+func getBuildSecrets(appValues map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	secrets := make(map[string]interface{})
+	rest := make(map[string]interface{}, len(appValues))
+	for key, value := range appValues {
+		rest[key] = value
+	}
+	names := appValueOrDefault(appValues, appBuildSecretsKey, "")
+	if names == "" {
+		return secrets, rest, nil
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		value, ok := appValues[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("build secret %s listed in %s is not set", name, appBuildSecretsKey)
+		}
+		secrets[name] = value
+		delete(rest, name)
+	}
+	return secrets, rest, nil
+}
+
+// createBuildSecrets stores the build secrets in a secret to be mounted in the builder pod. The
+// returned func deletes the secret.
+func createBuildSecrets(b *buildContext, secrets map[string]interface{}) (string, func(), error) {
+	secretName := fmt.Sprintf("%s-build-secrets", b.appName)
+	secretsClient := b.kubeClient.Secrets(b.conf.PodNamespace)
+	if err := createAppEnvConfigSecret(secretsClient, secretName, secrets); err != nil {
+		return "", nil, fmt.Errorf("error creating/updating secret %s: (%s)", secretName, err)
+	}
+	return secretName, func() {
+		if err := secretsClient.Delete(secretName); err != nil {
+			log.Info("unable to delete secret %s (%s)", secretName, err)
+		}
+	}, nil
+}
+
+// mountBuildSecrets mounts the build secrets stored in secretName into the builder container,
+// one file per secret, and tells the builder where to find them. The names of the secrets are
+// listed so that Docker builds can expose each of them as a secret mount.
+func mountBuildSecrets(pod *api.Pod, secretName string, secrets map[string]interface{}) {
+	pod.Spec.Volumes = append(pod.Spec.Volumes, api.Volume{
+		Name: buildSecretsName,
+		VolumeSource: api.VolumeSource{
+			Secret: &api.SecretVolumeSource{
+				SecretName: secretName,
+			},
+		},
+	})
+
+	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, api.VolumeMount{
+		Name:      buildSecretsName,
+		MountPath: buildSecretsPath,
+		ReadOnly:  true,
+	})
+
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	addEnvToPod(*pod, buildSecretsDirEnv, buildSecretsPath)
+	addEnvToPod(*pod, buildSecretsListEnv, strings.Join(names, ","))
+}
@@ -0,0 +1,47 @@
+package gitreceive
+
+import (
+	"testing"
+
+	"github.com/arschles/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestGetBuildSecrets(t *testing.T) {
+	appValues := map[string]interface{}{
+		appBuildSecretsKey: "NPM_TOKEN, GITHUB_TOKEN",
+		"NPM_TOKEN":        "npm",
+		"GITHUB_TOKEN":     "github",
+		"PORT":             "5000",
+	}
+	secrets, rest, err := getBuildSecrets(appValues)
+	assert.NoErr(t, err)
+	assert.Equal(t, secrets, map[string]interface{}{"NPM_TOKEN": "npm", "GITHUB_TOKEN": "github"}, "build secrets")
+	assert.Equal(t, rest, map[string]interface{}{appBuildSecretsKey: "NPM_TOKEN, GITHUB_TOKEN", "PORT": "5000"}, "rest of the app config")
+	assert.Equal(t, len(appValues), 4, "number of app config values")
+
+	secrets, rest, err = getBuildSecrets(map[string]interface{}{"PORT": "5000"})
+	assert.NoErr(t, err)
+	assert.Equal(t, len(secrets), 0, "number of build secrets")
+	assert.Equal(t, len(rest), 1, "number of other app config values")
+
+	_, _, err = getBuildSecrets(map[string]interface{}{appBuildSecretsKey: "NPM_TOKEN"})
+	assert.True(t, err != nil, "no error for a build secret that isn't set")
+}
+
+func TestMountBuildSecrets(t *testing.T) {
+	pod := &api.Pod{Spec: api.PodSpec{Containers: []api.Container{{}}}}
+	mountBuildSecrets(pod, "myapp-build-secrets", map[string]interface{}{"NPM_TOKEN": "npm", "GITHUB_TOKEN": "github"})
+
+	assert.Equal(t, len(pod.Spec.Volumes), 1, "number of volumes")
+	assert.Equal(t, pod.Spec.Volumes[0].Secret.SecretName, "myapp-build-secrets", "secret name")
+	assert.Equal(t, pod.Spec.Containers[0].VolumeMounts[0].MountPath, buildSecretsPath, "mount path")
+
+	env := make(map[string]string)
+	for _, e := range pod.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+		assert.False(t, e.Value == "npm" || e.Value == "github", "secret value in env var %s", e.Name)
+	}
+	assert.Equal(t, env[buildSecretsDirEnv], buildSecretsPath, "secrets dir")
+	assert.Equal(t, env[buildSecretsListEnv], "GITHUB_TOKEN,NPM_TOKEN", "secret names")
+}
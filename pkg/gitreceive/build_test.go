@@ -5,15 +5,20 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/arschles/assert"
 	builderconf "github.com/deis/builder/pkg/conf"
 	"github.com/deis/builder/pkg/storage"
 	"github.com/deis/builder/pkg/sys"
+	deis "github.com/deis/controller-sdk-go"
 	"github.com/deis/controller-sdk-go/api"
 	"github.com/deis/pkg/log"
 	"github.com/docker/distribution/context"
@@ -61,31 +66,36 @@ func TestBuild(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := build(config, storageDriver, nil, fs, env, "foo", sha); err == nil {
+	if err := build(config, storageDriver, nil, fs, env, "foo", sha, ""); err == nil {
 		t.Error("expected running build() without setting config.DockerBuilderImagePullPolicy to fail")
 	}
 
 	config.DockerBuilderImagePullPolicy = "Always"
-	if err := build(config, storageDriver, nil, fs, env, "foo", sha); err == nil {
+	if err := build(config, storageDriver, nil, fs, env, "foo", sha, ""); err == nil {
 		t.Error("expected running build() without setting config.SlugBuilderImagePullPolicy to fail")
 	}
 
 	config.SlugBuilderImagePullPolicy = "Always"
 
-	err = build(config, storageDriver, nil, fs, env, "foo", "abc123")
+	err = build(config, storageDriver, nil, fs, env, "foo", "abc123", "")
 	expected := "git sha abc123 was invalid"
 	if err.Error() != expected {
 		t.Errorf("expected '%s', got '%v'", expected, err.Error())
 	}
 
-	if err := build(config, storageDriver, nil, fs, env, "foo", sha); err == nil {
+	if err := build(config, storageDriver, nil, fs, env, "foo", sha, ""); err == nil {
+		t.Error("expected running build() without setting config.ObjectKeyLayout to fail")
+	}
+
+	config.ObjectKeyLayout = ObjectKeyLayoutV2
+	if err := build(config, storageDriver, nil, fs, env, "foo", sha, ""); err == nil {
 		t.Error("expected running build() without valid controller client info to fail")
 	}
 
 	config.ControllerHost = "localhost"
 	config.ControllerPort = "1234"
 
-	if err := build(config, storageDriver, nil, fs, env, "foo", sha); err == nil {
+	if err := build(config, storageDriver, nil, fs, env, "foo", sha, ""); err == nil {
 		t.Error("expected running build() without a valid builder key to fail")
 	}
 
@@ -95,11 +105,71 @@ func TestBuild(t *testing.T) {
 		t.Fatalf("error creating %s (%s)", builderconf.BuilderKeyLocation, err)
 	}
 
-	if err := build(config, storageDriver, nil, fs, env, "foo", sha); err == nil {
+	if err := build(config, storageDriver, nil, fs, env, "foo", sha, ""); err == nil {
 		t.Error("expected running build() without a valid controller connection to fail")
 	}
 }
 
+func TestBuildSkipsUntouchedBuildPaths(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tmpdir")
+	if err != nil {
+		t.Fatalf("error creating temp directory (%s)", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	repoDir := filepath.Join(tmpDir, "myapp.git")
+	// build creates the build directory without permissions if it's missing
+	if err := os.MkdirAll(filepath.Join(repoDir, "build"), 0755); err != nil {
+		t.Fatalf("error creating %s (%s)", repoDir, err)
+	}
+	commit := initTestRepo(t, repoDir)
+	built := commit("services/api/main.go")
+	pushed := commit("services/web/main.go")
+
+	builderconf.BuilderKeyLocation = filepath.Join(tmpDir, "builder-key")
+	if err := ioutil.WriteFile(builderconf.BuilderKeyLocation, []byte("testbuilderkey"), 0644); err != nil {
+		t.Fatalf("error creating %s (%s)", builderconf.BuilderKeyLocation, err)
+	}
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("DEIS_API_VERSION", deis.APIVersion)
+		// hooks are only served to the builder
+		if r.Header.Get("X-Deis-Builder-Auth") != "testbuilderkey" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requests = append(requests, r.URL.Path)
+		if r.URL.Path != "/v2/hooks/config/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"app": "myapp", "values": {%q: "services/api"}}`, appSourceDirKey)
+	}))
+	defer srv.Close()
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	assert.NoErr(t, err)
+
+	config := &Config{
+		GitHome:                      tmpDir,
+		Repository:                   "myapp.git",
+		ControllerHost:               host,
+		ControllerPort:               port,
+		DockerBuilderImagePullPolicy: "Always",
+		SlugBuilderImagePullPolicy:   "Always",
+		ObjectKeyLayout:              ObjectKeyLayoutV2,
+	}
+	storageDriver, err := factory.Create("inmemory", nil)
+	assert.NoErr(t, err)
+	assert.NoErr(t, recordLastBuiltSha(storageDriver, "myapp", built))
+
+	// the push doesn't touch services/api since its last build, so no builder pod is created
+	err = build(config, storageDriver, nil, sys.NewFakeFS(), sys.NewFakeEnv(), "testbuilderkey", pushed, "refs/heads/master")
+	assert.NoErr(t, err)
+	assert.Equal(t, requests, []string{"/v2/hooks/config/"}, "controller requests")
+	sha, err := lastBuiltSha(storageDriver, "myapp")
+	assert.NoErr(t, err)
+	assert.Equal(t, sha, built, "last built sha")
+}
+
 func TestRepoCmd(t *testing.T) {
 	cmd := repoCmd("/tmp", "ls")
 	if cmd.Dir != "/tmp" {
@@ -152,7 +222,10 @@ func TestGetProcFileFromRepoFailure(t *testing.T) {
 func TestGetProcFileFromServerSuccess(t *testing.T) {
 	data := []byte("web: example-go")
 	getter := &storage.FakeObjectGetter{
-		Fn: func(context.Context, string) ([]byte, error) {
+		Fn: func(ctx context.Context, path string) ([]byte, error) {
+			if path == storage.ChecksumKey(objKey) {
+				return []byte(storage.Checksum(data)), nil
+			}
 			return data, nil
 		},
 	}
@@ -164,6 +237,22 @@ func TestGetProcFileFromServerSuccess(t *testing.T) {
 	assert.Equal(t, procType, actualData, "data")
 }
 
+func TestGetProcFileFromServerCorrupted(t *testing.T) {
+	data := []byte("web: example-go")
+	getter := &storage.FakeObjectGetter{
+		Fn: func(ctx context.Context, path string) ([]byte, error) {
+			if path == storage.ChecksumKey(objKey) {
+				return []byte(storage.Checksum(data)), nil
+			}
+			return []byte("web= example-go"), nil
+		},
+	}
+
+	_, err := getProcFile(getter, "", objKey, buildTypeProcfile)
+	_, ok := err.(storage.CorruptedObjectError)
+	assert.True(t, ok, "expected an object corrupted error")
+}
+
 func TestGetProcFileFromServerFailure(t *testing.T) {
 	expectedErr := errors.New("test error")
 	getter := &storage.FakeObjectGetter{
@@ -3,8 +3,24 @@ package gitreceive
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/deis/builder/pkg/git"
+	"github.com/deis/builder/pkg/k8s"
+	"github.com/deis/builder/pkg/storage"
+	deisAPI "github.com/deis/controller-sdk-go/api"
+	"github.com/deis/pkg/log"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"k8s.io/kubernetes/pkg/api"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
 )
 
+// buildStrategyKey is the app config key that forces the build strategy of an app, bypassing
+// detection.
+const buildStrategyKey = "DEIS_BUILD_STRATEGY"
+
 type buildType string
 
 func (b buildType) String() string {
@@ -14,12 +30,361 @@ func (b buildType) String() string {
 const (
 	buildTypeProcfile   buildType = "procfile"
 	buildTypeDockerfile buildType = "dockerfile"
+	buildTypeCNB        buildType = "cnb"
+	buildTypeImage      buildType = "image"
 )
 
-func getBuildTypeForDir(dirName string) buildType {
-	_, err := os.Stat(fmt.Sprintf("%s/Dockerfile", dirName))
-	if err == nil {
-		return buildTypeDockerfile
+// buildContext holds everything a build strategy may need to create its builder pod.
+type buildContext struct {
+	conf                         *Config
+	kubeClient                   *client.Client
+	storage                      storagedriver.StorageDriver
+	dir                          string // the extracted source tree
+	appName                      string
+	appValues                    map[string]interface{}
+	gitSha                       *git.SHA
+	sha                          string // the git sha the build is keyed and tagged by
+	slugName                     string
+	slugBuilderInfo              *SlugBuilderInfo
+	buildPackURL                 string
+	dockerBuilderImagePullPolicy api.PullPolicy
+	slugBuilderImagePullPolicy   api.PullPolicy
+	nodeSelector                 map[string]string
+	template                     *api.Pod
+	redactor                     *redactor
+	// mirrors are the registries the builder pod pushes the image to in addition to the primary one
+	mirrors []registryMirror
+}
+
+// buildStrategy is a way of turning a source tree into something the controller can release.
+type buildStrategy struct {
+	name buildType
+	// detect returns true if the strategy recognizes the source tree in dir, along with the reason
+	detect func(dir string, appValues map[string]interface{}) (bool, string)
+	// releasesImage is true if the strategy pushes a container image rather than a slug
+	releasesImage bool
+	// pod creates the builder pod of the strategy and returns it along with the image to release.
+	// The pod is nil if there's nothing to build. cleanup, if not nil, must be called once the
+	// build is over.
+	pod func(b *buildContext) (pod *api.Pod, image string, cleanup func(), err error)
+	// procTypes returns the process types of the app once its builder pod succeeded
+	procTypes func(b *buildContext, getter storage.ObjectGetter, dir string) (deisAPI.ProcessType, error)
+	// digest returns the digest of the image or slug to release once its builder pod succeeded
+	digest func(b *buildContext, image string) (string, error)
+}
+
+// buildStrategies holds every build strategy, in the order they're tried during detection. The
+// last one recognizes any source tree.
+var buildStrategies = []buildStrategy{
+	{
+		name:          buildTypeImage,
+		detect:        deployManifestDetector,
+		releasesImage: true,
+		pod:           imageStrategyPod,
+		procTypes:     imageProcTypes,
+		digest:        imageReferenceDigest,
+	},
+	{
+		name:          buildTypeDockerfile,
+		detect:        dockerfileDetector,
+		releasesImage: true,
+		pod:           dockerfileStrategyPod,
+		procTypes:     procfileProcTypes(buildTypeDockerfile),
+		digest:        dockerfileDigest,
+	},
+	{
+		name:          buildTypeCNB,
+		detect:        fileDetector("project.toml"),
+		releasesImage: true,
+		pod:           cnbStrategyPod,
+		procTypes: func(b *buildContext, getter storage.ObjectGetter, dir string) (deisAPI.ProcessType, error) {
+			return getCNBProcessTypes(getter, cnbMetadataKey(b.slugBuilderInfo.PushKey()))
+		},
+		digest: cnbDigest,
+	},
+	{
+		name: buildTypeProcfile,
+		detect: func(string, map[string]interface{}) (bool, string) {
+			return true, "no other build strategy matched, defaulting to buildpacks"
+		},
+		pod:       procfileStrategyPod,
+		procTypes: procfileProcTypes(buildTypeProcfile),
+		digest:    slugDigest,
+	},
+}
+
+// fileDetector returns a detect func that recognizes source trees containing the named file.
+func fileDetector(name string) func(dir string, appValues map[string]interface{}) (bool, string) {
+	return func(dir string, appValues map[string]interface{}) (bool, string) {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false, ""
+		}
+		return true, fmt.Sprintf("found %s", name)
+	}
+}
+
+// dockerfileDetector recognizes source trees containing a Dockerfile, at the path set in the app
+// config if any.
+func dockerfileDetector(dir string, appValues map[string]interface{}) (bool, string) {
+	dockerfile, err := getDockerfilePath(appValues)
+	if err != nil {
+		return false, ""
+	}
+	return fileDetector(dockerfile)(dir, appValues)
+}
+
+// selectBuildStrategy returns the strategy forced by the app config, or the first strategy that
+// recognizes the source tree in dir, along with the reason it was picked.
+func selectBuildStrategy(dir string, appValues map[string]interface{}) (buildStrategy, string, error) {
+	if forced := appValueOrDefault(appValues, buildStrategyKey, ""); forced != "" {
+		names := make([]string, len(buildStrategies))
+		for i, strategy := range buildStrategies {
+			if string(strategy.name) == forced {
+				return strategy, fmt.Sprintf("forced by %s", buildStrategyKey), nil
+			}
+			names[i] = string(strategy.name)
+		}
+		return buildStrategy{}, "", fmt.Errorf("unknown build strategy %q in %s, must be one of %s", forced, buildStrategyKey, strings.Join(names, ", "))
+	}
+	for _, strategy := range buildStrategies {
+		if ok, reason := strategy.detect(dir, appValues); ok {
+			return strategy, reason, nil
+		}
+	}
+	return buildStrategy{}, "", fmt.Errorf("no build strategy recognizes the source tree")
+}
+
+// procfileProcTypes returns a procTypes func reading the Procfile of the source tree, falling back
+// to the one generated by slugbuilder for procfile builds.
+func procfileProcTypes(bType buildType) func(b *buildContext, getter storage.ObjectGetter, dir string) (deisAPI.ProcessType, error) {
+	return func(b *buildContext, getter storage.ObjectGetter, dir string) (deisAPI.ProcessType, error) {
+		return getProcFile(getter, dir, b.slugBuilderInfo.AbsoluteProcfileKey(), bType)
+	}
+}
+
+// dockerfileStrategyPod creates the builder pod of apps built from a Dockerfile, using the
+// Dockerfile build backend selected for the app. Build secrets are mounted from a secret, which
+// cleanup deletes.
+func dockerfileStrategyPod(b *buildContext) (*api.Pod, string, func(), error) {
+	conf := b.conf
+	image, registryEnv, err := registryDetails(b)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	mirrors, err := registryMirrors(b)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	backendName, backend, err := getDockerBuildBackend(conf, b.appValues)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	log.Debug("Using the %s Dockerfile build backend", backendName)
+
+	buildOptions, err := newDockerBuildOptions(b.appValues)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// build secrets must not end up in the pod env or in the build args baked into the image
+	secrets, env, err := getBuildSecrets(b.appValues)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	dockerCacheKey := ""
+	if !b.slugBuilderInfo.DisableCaching() {
+		dockerCacheKey = b.slugBuilderInfo.DockerCacheKey()
+	}
+
+	pod, err := backend.pod(
+		conf.Debug,
+		dockerBuilderPodName(b.appName, b.gitSha.Short()),
+		conf.PodNamespace,
+		env,
+		b.slugBuilderInfo.TarKey(),
+		dockerCacheKey,
+		b.sha,
+		b.slugName,
+		conf.StorageType,
+		backend.image(conf),
+		conf.RegistryHost,
+		conf.RegistryPort,
+		registryEnv,
+		b.dockerBuilderImagePullPolicy,
+		b.nodeSelector,
+		b.template,
+	)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	for key, value := range buildOptions.env() {
+		addEnvToPod(*pod, key, value)
+	}
+	addEnvToPod(*pod, pushResultsPath, pushResultsKey(b.slugBuilderInfo.PushKey()))
+	if len(mirrors) > 0 {
+		for key, value := range mirrorsEnv(mirrors) {
+			addEnvToPod(*pod, key, value)
+		}
+		b.mirrors = mirrors
+	}
+	if len(secrets) == 0 {
+		return pod, image, nil, nil
+	}
+	secretName, cleanup, err := createBuildSecrets(b, secrets)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	mountBuildSecrets(pod, secretName, secrets)
+	return pod, image, cleanup, nil
+}
+
+// procfileStrategyPod creates the slugbuilder pod of apps built with buildpacks. The app config is
+// passed to the pod in a secret, which cleanup deletes.
+func procfileStrategyPod(b *buildContext) (*api.Pod, string, func(), error) {
+	conf := b.conf
+	cacheKey := ""
+	if !b.slugBuilderInfo.DisableCaching() {
+		cacheKey = b.slugBuilderInfo.CacheKey()
+		fingerprint := cacheFingerprint(b.buildPackURL, conf.SlugBuilderImage)
+		if err := prepareBuildpackCache(b.storage, cacheKey, fingerprint, time.Now()); err != nil {
+			log.Info("Unable to update the build cache metadata (%s)", err)
+		}
+	}
+	envSecretName, cleanup, err := createBuildEnvSecret(b)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	pod, err := slugbuilderPod(
+		conf.Debug,
+		slugBuilderPodName(b.appName, b.gitSha.Short()),
+		conf.PodNamespace,
+		envSecretName,
+		b.slugBuilderInfo.TarKey(),
+		b.slugBuilderInfo.PushKey(),
+		cacheKey,
+		b.sha,
+		b.buildPackURL,
+		conf.StorageType,
+		conf.SlugBuilderImage,
+		b.slugBuilderImagePullPolicy,
+		b.nodeSelector,
+		b.template,
+	)
+	if err != nil {
+		cleanup()
+		return nil, "", nil, err
+	}
+	return pod, b.slugBuilderInfo.AbsoluteSlugObjectKey(), cleanup, nil
+}
+
+// cnbStrategyPod creates the builder pod of apps built with Cloud Native Buildpacks. The app
+// config is passed to the pod in a secret, which cleanup deletes.
+func cnbStrategyPod(b *buildContext) (*api.Pod, string, func(), error) {
+	conf := b.conf
+	if conf.CNBBuilderImage == "" {
+		return nil, "", nil, fmt.Errorf("Cloud Native Buildpacks builds are disabled, no CNB builder image is configured")
+	}
+	pullPolicy, err := k8s.PullPolicyFromString(conf.CNBBuilderImagePullPolicy)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	image, registryEnv, err := registryDetails(b)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	envSecretName, cleanup, err := createBuildEnvSecret(b)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	pod, err := cnbBuilderPod(
+		conf.Debug,
+		cnbBuilderPodName(b.appName, b.gitSha.Short()),
+		conf.PodNamespace,
+		envSecretName,
+		b.slugBuilderInfo.TarKey(),
+		cnbMetadataKey(b.slugBuilderInfo.PushKey()),
+		b.sha,
+		b.slugName,
+		conf.StorageType,
+		conf.CNBBuilderImage,
+		conf.RegistryHost,
+		conf.RegistryPort,
+		registryEnv,
+		pullPolicy,
+		b.nodeSelector,
+		b.template,
+	)
+	if err != nil {
+		cleanup()
+		return nil, "", nil, err
+	}
+	return pod, image, cleanup, nil
+}
+
+// registryDetails returns the image to release for builds pushing to the primary registry, and
+// the env vars their builder pod needs to push to it.
+func registryDetails(b *buildContext) (string, map[string]string, error) {
+	targets, err := registryTargets(b.conf)
+	if err != nil {
+		return "", nil, err
+	}
+	return targetRegistryDetails(b, targets[0])
+}
+
+// targetRegistryDetails returns the image builds push to target, and the env vars their builder
+// pod needs to push to it.
+func targetRegistryDetails(b *buildContext, target registryTarget) (string, map[string]string, error) {
+	conf := b.conf
+	image := b.appName
+	registryEnv := make(map[string]string)
+	if target.location != onClusterRegistry {
+		var err error
+		registryEnv, err = getRegistryDetails(b.kubeClient, &image, targetRegistryOptions(b, target))
+		if err != nil {
+			return "", nil, fmt.Errorf("error getting private registry details %s", err)
+		}
+		image = image + ":git-" + b.sha
+	}
+	registryEnv["DEIS_REGISTRY_PROXY_PORT"] = conf.RegistryProxyPort
+	registryEnv["DEIS_REGISTRY_LOCATION"] = target.location
+	if b.redactor != nil {
+		log.Debug("pushing %s with registry details %v", image, b.redactor.strings(registryEnv))
+	}
+	return image, registryEnv, nil
+}
+
+// targetRegistryOptions returns the options of the provider of the registry at target.
+func targetRegistryOptions(b *buildContext, target registryTarget) registryOptions {
+	hostname := b.conf.RegistryHostname
+	if target.hostname != "" {
+		hostname = target.hostname
+	}
+	return registryOptions{
+		location:     target.location,
+		namespace:    b.conf.PodNamespace,
+		secretPrefix: b.conf.RegistrySecretPrefix,
+		hostname:     hostname,
+		organization: b.conf.RegistryOrganization,
+	}
+}
+
+// createBuildEnvSecret stores the app config in a secret to be mounted in the builder pod. The
+// returned func deletes the secret.
+func createBuildEnvSecret(b *buildContext) (string, func(), error) {
+	envSecretName := fmt.Sprintf("%s-build-env", b.appName)
+	secrets := b.kubeClient.Secrets(b.conf.PodNamespace)
+	if err := createAppEnvConfigSecret(secrets, envSecretName, b.appValues); err != nil {
+		return "", nil, fmt.Errorf("error creating/updating secret %s: (%s)", envSecretName, err)
 	}
-	return buildTypeProcfile
+	return envSecretName, func() {
+		if err := secrets.Delete(envSecretName); err != nil {
+			log.Info("unable to delete secret %s (%s)", envSecretName, err)
+		}
+	}, nil
 }
@@ -1,13 +1,26 @@
 package gitreceive
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
-func TestGetBuildTypeForDir(t *testing.T) {
+// detectedBuildType returns the name of the build strategy selected for dirName, failing the test
+// if none is.
+func detectedBuildType(t *testing.T, dirName string, appValues map[string]interface{}) buildType {
+	strategy, _, err := selectBuildStrategy(dirName, appValues)
+	if err != nil {
+		t.Fatalf("error selecting build strategy (%s)", err)
+	}
+	return strategy.name
+}
+
+func TestSelectBuildStrategyDockerfile(t *testing.T) {
 	tmpDir := os.TempDir()
-	bType := getBuildTypeForDir(tmpDir)
+	bType := detectedBuildType(t, tmpDir, nil)
 	if bType != buildTypeProcfile {
 		t.Fatalf("expected procfile build, got %s", bType)
 	}
@@ -20,8 +33,86 @@ func TestGetBuildTypeForDir(t *testing.T) {
 		}
 	}()
 
-	bType = getBuildTypeForDir(tmpDir)
+	bType = detectedBuildType(t, tmpDir, nil)
 	if bType != buildTypeDockerfile {
 		t.Fatalf("expected dockerfile build, got %s", bType)
 	}
 }
+
+func TestSelectBuildStrategyDockerfilePath(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tmpdir")
+	if err != nil {
+		t.Fatalf("error creating temp directory (%s)", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := os.MkdirAll(filepath.Join(tmpDir, "docker"), 0755); err != nil {
+		t.Fatalf("error creating %s/docker (%s)", tmpDir, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "docker", "Dockerfile.prod"), []byte("FROM scratch"), 0644); err != nil {
+		t.Fatalf("error creating docker/Dockerfile.prod (%s)", err)
+	}
+
+	if bType := detectedBuildType(t, tmpDir, nil); bType != buildTypeProcfile {
+		t.Fatalf("expected procfile build without Dockerfile path, got %s", bType)
+	}
+	appValues := map[string]interface{}{appDockerfilePathKey: "docker/Dockerfile.prod"}
+	if bType := detectedBuildType(t, tmpDir, appValues); bType != buildTypeDockerfile {
+		t.Fatalf("expected dockerfile build with Dockerfile path, got %s", bType)
+	}
+}
+
+func TestSelectBuildStrategy(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tmpdir")
+	if err != nil {
+		t.Fatalf("error creating temp directory (%s)", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	strategy, reason, err := selectBuildStrategy(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("error selecting build strategy (%s)", err)
+	}
+	if strategy.name != buildTypeProcfile || reason == "" {
+		t.Fatalf("expected procfile build with a reason, got %s (%s)", strategy.name, reason)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "project.toml"), []byte(""), 0644); err != nil {
+		t.Fatalf("error creating project.toml (%s)", err)
+	}
+	strategy, reason, err = selectBuildStrategy(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("error selecting build strategy (%s)", err)
+	}
+	if strategy.name != buildTypeCNB || reason != "found project.toml" {
+		t.Fatalf("expected cnb build because of project.toml, got %s (%s)", strategy.name, reason)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "Dockerfile"), []byte("FROM scratch"), 0644); err != nil {
+		t.Fatalf("error creating Dockerfile (%s)", err)
+	}
+	strategy, reason, err = selectBuildStrategy(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("error selecting build strategy (%s)", err)
+	}
+	if strategy.name != buildTypeDockerfile || reason != "found Dockerfile" {
+		t.Fatalf("expected dockerfile build because of the Dockerfile, got %s (%s)", strategy.name, reason)
+	}
+	if !strategy.releasesImage {
+		t.Fatalf("expected dockerfile builds to release an image")
+	}
+
+	appValues := map[string]interface{}{buildStrategyKey: "procfile"}
+	strategy, reason, err = selectBuildStrategy(tmpDir, appValues)
+	if err != nil {
+		t.Fatalf("error selecting build strategy (%s)", err)
+	}
+	if strategy.name != buildTypeProcfile || !strings.Contains(reason, buildStrategyKey) {
+		t.Fatalf("expected procfile build forced by %s, got %s (%s)", buildStrategyKey, strategy.name, reason)
+	}
+
+	appValues = map[string]interface{}{buildStrategyKey: "nix"}
+	if _, _, err := selectBuildStrategy(tmpDir, appValues); err == nil {
+		t.Fatalf("expected an error for an unknown build strategy")
+	}
+}
@@ -0,0 +1,131 @@
+package gitreceive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/deis/builder/pkg/storage"
+	"github.com/deis/pkg/log"
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// CacheMetadataKeyPattern is the template for the location of the metadata of the buildpack cache
+// of the default branch of an app. The metadata of every cache is stored next to it.
+const CacheMetadataKeyPattern = "home/%s/cache.json"
+
+// CacheMetadata describes a buildpack cache.
+type CacheMetadata struct {
+	// Fingerprint identifies the buildpack and builder image the cache was created with
+	Fingerprint string `json:"fingerprint"`
+	// Size is the size in bytes of the cache when the last build using it finished
+	Size int64 `json:"size"`
+	// LastUsed is the time the last build using the cache started
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+// CacheMetadataKey returns the location of the metadata of the buildpack cache at cacheKey.
+func CacheMetadataKey(cacheKey string) string {
+	return cacheKey + ".json"
+}
+
+// IsCacheMetadataKey returns true if key is the location of the metadata of a buildpack cache, or
+// of its checksum, rather than of a cache.
+func IsCacheMetadataKey(key string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(key, storage.ChecksumSuffix), ".json")
+}
+
+// cacheFingerprint returns the fingerprint of the buildpack cache of builds using the given
+// custom buildpack URL, if any, and slugbuilder image. A cache created with a different
+// fingerprint may not be compatible with the build.
+func cacheFingerprint(buildpackURL, builderImage string) string {
+	sum := sha256.Sum256([]byte(buildpackURL + "\n" + builderImage))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetCacheMetadata returns the metadata of the buildpack cache at cacheKey.
+func GetCacheMetadata(getter storage.ObjectGetter, cacheKey string) (*CacheMetadata, error) {
+	key := CacheMetadataKey(cacheKey)
+	raw, err := storage.GetContentWithChecksum(getter, key)
+	if err != nil {
+		return nil, err
+	}
+	metadata := new(CacheMetadata)
+	if err := json.Unmarshal(raw, metadata); err != nil {
+		return nil, fmt.Errorf("cache metadata %s is malformed (%s)", key, err)
+	}
+	return metadata, nil
+}
+
+func putCacheMetadata(putter storage.ObjectPutter, cacheKey string, metadata *CacheMetadata) error {
+	raw, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	_, err = storage.PutContentWithChecksum(putter, CacheMetadataKey(cacheKey), raw)
+	return err
+}
+
+// prepareBuildpackCache deletes the buildpack cache at cacheKey if it was created with a different
+// fingerprint, then records that a build with fingerprint is using it.
+func prepareBuildpackCache(driver storagedriver.StorageDriver, cacheKey, fingerprint string, now time.Time) error {
+	metadata, err := GetCacheMetadata(driver, cacheKey)
+	if err != nil {
+		metadata = new(CacheMetadata)
+	} else if metadata.Fingerprint != fingerprint {
+		log.Info("The buildpack or builder image changed, clearing the build cache")
+		if err := deleteIfExists(driver, cacheKey); err != nil {
+			return err
+		}
+		metadata.Size = 0
+	}
+	metadata.Fingerprint = fingerprint
+	metadata.LastUsed = now
+	return putCacheMetadata(driver, cacheKey, metadata)
+}
+
+// recordBuildpackCacheSize records the size of the buildpack cache at cacheKey once a build wrote
+// it.
+func recordBuildpackCacheSize(driver storagedriver.StorageDriver, cacheKey string) error {
+	metadata, err := GetCacheMetadata(driver, cacheKey)
+	if err != nil {
+		return err
+	}
+	info, err := driver.Stat(context.Background(), cacheKey)
+	if err != nil {
+		return err
+	}
+	metadata.Size = info.Size()
+	return putCacheMetadata(driver, cacheKey, metadata)
+}
+
+// PurgeCache deletes the buildpack caches of every branch and the Dockerfile build cache of
+// appName, along with their metadata, so that the next build starts from scratch.
+func PurgeCache(deleter storage.ObjectDeleter, appName string) error {
+	metadataKey := fmt.Sprintf(CacheMetadataKeyPattern, appName)
+	keys := []string{
+		fmt.Sprintf(CacheKeyPattern, appName),
+		fmt.Sprintf(BranchCachesKeyPattern, appName),
+		fmt.Sprintf(DockerCacheKeyPattern, appName),
+		metadataKey,
+		storage.ChecksumKey(metadataKey),
+	}
+	for _, key := range keys {
+		if err := deleteIfExists(deleter, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteIfExists(deleter storage.ObjectDeleter, key string) error {
+	err := deleter.Delete(context.Background(), key)
+	if _, ok := err.(storagedriver.PathNotFoundError); err != nil && !ok {
+		return fmt.Errorf("deleting %s (%s)", key, err)
+	}
+	return nil
+}
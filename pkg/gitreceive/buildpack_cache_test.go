@@ -0,0 +1,83 @@
+package gitreceive
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arschles/assert"
+	"github.com/deis/builder/pkg/storage"
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+	_ "github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestCacheFingerprint(t *testing.T) {
+	fingerprint := cacheFingerprint("", "quay.io/deis/slugbuilder:v2.0.0")
+	assert.Equal(t, fingerprint, cacheFingerprint("", "quay.io/deis/slugbuilder:v2.0.0"), "fingerprint")
+	assert.False(t, fingerprint == cacheFingerprint("", "quay.io/deis/slugbuilder:v2.1.0"), "builder image change not detected")
+	assert.False(t, fingerprint == cacheFingerprint("https://github.com/heroku/heroku-buildpack-go", "quay.io/deis/slugbuilder:v2.0.0"), "buildpack change not detected")
+}
+
+func TestGetCacheMetadata(t *testing.T) {
+	getter := &storage.FakeObjectGetter{
+		Fn: func(ctx context.Context, path string) ([]byte, error) {
+			if strings.HasSuffix(path, storage.ChecksumSuffix) {
+				return nil, storagedriver.PathNotFoundError{Path: path}
+			}
+			return []byte(`{"fingerprint":"abc","size":42,"lastUsed":"2016-01-02T15:04:05Z"}`), nil
+		},
+	}
+	metadata, err := GetCacheMetadata(getter, "home/myapp/cache")
+	assert.NoErr(t, err)
+	assert.Equal(t, metadata.Fingerprint, "abc", "fingerprint")
+	assert.Equal(t, metadata.Size, int64(42), "size")
+	assert.Equal(t, getter.Calls[0].Path, "home/myapp/cache.json", "object key")
+}
+
+func TestBuildpackCacheSize(t *testing.T) {
+	driver, err := factory.Create("inmemory", nil)
+	assert.NoErr(t, err)
+	cacheKey := "home/myapp/caches/feature-login"
+	assert.NoErr(t, prepareBuildpackCache(driver, cacheKey, "abc", time.Now()))
+	assert.NoErr(t, driver.PutContent(context.Background(), cacheKey, []byte("cache")))
+
+	metadata, err := GetCacheMetadata(driver, cacheKey)
+	assert.NoErr(t, err)
+	assert.Equal(t, metadata.Size, int64(0), "size before the build wrote the cache")
+	assert.NoErr(t, recordBuildpackCacheSize(driver, cacheKey))
+	metadata, err = GetCacheMetadata(driver, cacheKey)
+	assert.NoErr(t, err)
+	assert.Equal(t, metadata.Size, int64(5), "size after the build wrote the cache")
+
+	assert.NoErr(t, prepareBuildpackCache(driver, cacheKey, "abc", time.Now()))
+	metadata, err = GetCacheMetadata(driver, cacheKey)
+	assert.NoErr(t, err)
+	assert.Equal(t, metadata.Size, int64(5), "size kept by the next build")
+
+	assert.NoErr(t, prepareBuildpackCache(driver, cacheKey, "def", time.Now()))
+	_, err = driver.Stat(context.Background(), cacheKey)
+	assert.True(t, err != nil, "cache of another fingerprint was not deleted")
+}
+
+func TestPurgeCache(t *testing.T) {
+	deleter := &storage.FakeObjectDeleter{
+		Fn: func(ctx context.Context, path string) error {
+			return storagedriver.PathNotFoundError{Path: path}
+		},
+	}
+	assert.NoErr(t, PurgeCache(deleter, "myapp"))
+	assert.Equal(t, len(deleter.Calls), 5, "number of Delete calls")
+	assert.Equal(t, deleter.Calls[0].Path, "home/myapp/cache", "cache key")
+	assert.Equal(t, deleter.Calls[1].Path, "home/myapp/caches", "branch caches key")
+	assert.Equal(t, deleter.Calls[2].Path, "home/myapp/docker-cache", "docker cache key")
+	assert.Equal(t, deleter.Calls[3].Path, "home/myapp/cache.json", "metadata key")
+	assert.Equal(t, deleter.Calls[4].Path, "home/myapp/cache.json.sha256", "metadata checksum key")
+
+	deleter.Fn = func(context.Context, string) error {
+		return errors.New("test error")
+	}
+	assert.True(t, PurgeCache(deleter, "otherapp") != nil, "no error returned when the delete failed")
+}
@@ -0,0 +1,168 @@
+package gitreceive
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/deis/builder/pkg/storage"
+	deisAPI "github.com/deis/controller-sdk-go/api"
+	"github.com/pborman/uuid"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	cnbBuilderName = "deis-cnbbuilder"
+
+	// cnbPlatformEnvRoot is where the lifecycle reads the build time env vars from, one file each
+	cnbPlatformEnvRoot = "/platform/env"
+	cnbMetadataPath    = "METADATA_PATH"
+	cnbMetadataName    = "build-metadata.json"
+	// cnbMetadataChecksumPath is where the CNB builder pod uploads the hex encoded sha256 of the
+	// launch metadata, so the builder can verify it
+	cnbMetadataChecksumPath = "METADATA_CHECKSUM_PATH"
+)
+
+// cnbMetadata is the launch metadata the lifecycle stores in the io.buildpacks.build.metadata label
+// of the images it builds. The CNB builder pod uploads it to object storage once the image is
+// pushed.
+type cnbMetadata struct {
+	Processes []cnbProcess `json:"processes"`
+	// Digest is the digest of the pushed image, added by the CNB builder pod
+	Digest string `json:"digest,omitempty"`
+}
+
+// cnbProcess is a process type of a CNB image. The command of a direct process is an executable,
+// while the one of other processes is a shell script, run by the launcher with the args appended.
+type cnbProcess struct {
+	Type    string   `json:"type"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Direct  bool     `json:"direct"`
+}
+
+var shellSafeRegex = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+func cnbBuilderPodName(appName, shortSha string) string {
+	uid := uuid.New()[:8]
+	// pod names cannot exceed 63 characters in length, so we truncate the application name to
+	// stay under that limit when adding all the extra metadata to the name
+	if len(appName) > 36 {
+		appName = appName[:36]
+	}
+	return fmt.Sprintf("cnbbuild-%s-%s-%s", appName, shortSha, uid)
+}
+
+// cnbMetadataKey returns the object storage key the CNB builder pod uploads the launch metadata of
+// the image to.
+func cnbMetadataKey(pushKey string) string {
+	return pushKey + "/" + cnbMetadataName
+}
+
+// cnbBuilderPod creates a builder pod that runs the Cloud Native Buildpacks lifecycle. The builder
+// image downloads the source tree from TAR_PATH, builds it into an OCI image pushed as IMG_NAME
+// using the DEIS_REGISTRY_* credentials, and uploads the launch metadata of the image to
+// METADATA_PATH. The app config is mounted from envSecretName as the platform env of the lifecycle.
+func cnbBuilderPod(
+	debug bool,
+	name,
+	namespace string,
+	envSecretName string,
+	tarKey,
+	metadataKey,
+	gitSha string,
+	imageName,
+	storageType,
+	image,
+	registryHost,
+	registryPort string,
+	registryEnv map[string]string,
+	pullPolicy api.PullPolicy,
+	nodeSelector map[string]string,
+	template *api.Pod,
+) (*api.Pod, error) {
+
+	pod, err := buildPod(debug, name, namespace, pullPolicy, nodeSelector, nil, template)
+	if err != nil {
+		return nil, err
+	}
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, api.Volume{
+		Name: envSecretName,
+		VolumeSource: api.VolumeSource{
+			Secret: &api.SecretVolumeSource{
+				SecretName: envSecretName,
+			},
+		},
+	})
+
+	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, api.VolumeMount{
+		Name:      envSecretName,
+		MountPath: cnbPlatformEnvRoot,
+		ReadOnly:  true,
+	})
+
+	pod.Spec.Containers[0].Name = cnbBuilderName
+	pod.Spec.Containers[0].Image = image
+
+	addEnvToPod(pod, tarPath, tarKey)
+	addEnvToPod(pod, cnbMetadataPath, metadataKey)
+	addEnvToPod(pod, cnbMetadataChecksumPath, storage.ChecksumKey(metadataKey))
+	addEnvToPod(pod, sourceVersion, gitSha)
+	addEnvToPod(pod, "IMG_NAME", imageName)
+	addEnvToPod(pod, builderStorage, storageType)
+	addEnvToPod(pod, "DEIS_REGISTRY_SERVICE_HOST", registryHost)
+	addEnvToPod(pod, "DEIS_REGISTRY_SERVICE_PORT", registryPort)
+
+	for key, value := range registryEnv {
+		addEnvToPod(pod, key, value)
+	}
+
+	return &pod, nil
+}
+
+// getCNBMetadata reads the launch metadata uploaded by the CNB builder pod, verifying it against
+// its checksum.
+func getCNBMetadata(getter storage.ObjectGetter, metadataKey string) (*cnbMetadata, error) {
+	rawMetadata, err := storage.GetContentWithChecksum(getter, metadataKey)
+	if err != nil {
+		return nil, fmt.Errorf("error in reading %s (%s)", metadataKey, err)
+	}
+	metadata := new(cnbMetadata)
+	if err := json.Unmarshal(rawMetadata, metadata); err != nil {
+		return nil, fmt.Errorf("launch metadata %s is malformed (%s)", metadataKey, err)
+	}
+	return metadata, nil
+}
+
+// getCNBProcessTypes reads the process types of a CNB image from the launch metadata uploaded by
+// the CNB builder pod. Args, and the command of direct processes, are shell quoted so that the
+// Procfile entry runs the same command line.
+func getCNBProcessTypes(getter storage.ObjectGetter, metadataKey string) (deisAPI.ProcessType, error) {
+	metadata, err := getCNBMetadata(getter, metadataKey)
+	if err != nil {
+		return nil, err
+	}
+	procType := deisAPI.ProcessType{}
+	for _, process := range metadata.Processes {
+		command := process.Command
+		if process.Direct {
+			command = shellQuote(command)
+		}
+		words := []string{command}
+		for _, arg := range process.Args {
+			words = append(words, shellQuote(arg))
+		}
+		procType[process.Type] = strings.Join(words, " ")
+	}
+	return procType, nil
+}
+
+// shellQuote returns word quoted for a POSIX shell, or as is if the shell wouldn't change it.
+func shellQuote(word string) string {
+	if word != "" && shellSafeRegex.MatchString(word) {
+		return word
+	}
+	return "'" + strings.Replace(word, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,105 @@
+package gitreceive
+
+import (
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/deis/builder/pkg/storage"
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestCNBBuilderPod(t *testing.T) {
+	registryEnv := map[string]string{"DEIS_REGISTRY_USERNAME": "user"}
+	pod, err := cnbBuilderPod(
+		false,
+		"test",
+		"default",
+		"myapp-build-env",
+		"home/myapp:git-c3b4e4ba/tar",
+		"home/myapp:git-c3b4e4ba/push/build-metadata.json",
+		"c3b4e4ba",
+		"myapp:git-c3b4e4ba",
+		"minio",
+		"cnbbuilder",
+		"localhost",
+		"5555",
+		registryEnv,
+		api.PullAlways,
+		nil,
+		nil,
+	)
+	assert.NoErr(t, err)
+
+	container := pod.Spec.Containers[0]
+	assert.Equal(t, container.Name, cnbBuilderName, "container name")
+	assert.Equal(t, container.Image, "cnbbuilder", "container image")
+
+	env := make(map[string]string)
+	for _, e := range container.Env {
+		env[e.Name] = e.Value
+	}
+	assert.Equal(t, env[tarPath], "home/myapp:git-c3b4e4ba/tar", "tar path")
+	assert.Equal(t, env[cnbMetadataPath], "home/myapp:git-c3b4e4ba/push/build-metadata.json", "metadata path")
+	assert.Equal(t, env[cnbMetadataChecksumPath], "home/myapp:git-c3b4e4ba/push/build-metadata.json.sha256", "metadata checksum path")
+	assert.Equal(t, env["IMG_NAME"], "myapp:git-c3b4e4ba", "image name")
+	assert.Equal(t, env["DEIS_REGISTRY_USERNAME"], "user", "registry username")
+
+	found := false
+	for _, mount := range container.VolumeMounts {
+		if mount.Name == "myapp-build-env" {
+			found = true
+			assert.Equal(t, mount.MountPath, cnbPlatformEnvRoot, "platform env mount path")
+		}
+	}
+	assert.True(t, found, "app config secret is not mounted")
+}
+
+func TestGetCNBProcessTypes(t *testing.T) {
+	metadata := []byte(`{"processes":[` +
+		`{"type":"web","command":"bundle exec rackup","args":["--host","0.0.0.0"]},` +
+		`{"type":"worker","command":"./worker","direct":true},` +
+		`{"type":"clock","command":"/usr/bin/clock","args":["--name","a b","it's; rm -rf /"],"direct":true}]}`)
+	getter := &storage.FakeObjectGetter{
+		Fn: func(ctx context.Context, path string) ([]byte, error) {
+			if path == storage.ChecksumKey("home/myapp:git-c3b4e4ba/push/build-metadata.json") {
+				return []byte(storage.Checksum(metadata)), nil
+			}
+			return metadata, nil
+		},
+	}
+	procType, err := getCNBProcessTypes(getter, "home/myapp:git-c3b4e4ba/push/build-metadata.json")
+	assert.NoErr(t, err)
+	assert.Equal(t, len(procType), 3, "number of process types")
+	// the command of processes that aren't direct is a shell script, kept as is
+	assert.Equal(t, procType["web"], "bundle exec rackup --host 0.0.0.0", "web process")
+	assert.Equal(t, procType["worker"], "./worker", "worker process")
+	assert.Equal(t, procType["clock"], `/usr/bin/clock --name 'a b' 'it'\''s; rm -rf /'`, "clock process")
+	assert.Equal(t, len(getter.Calls), 2, "number of calls")
+	assert.Equal(t, getter.Calls[0].Path, "home/myapp:git-c3b4e4ba/push/build-metadata.json", "path")
+
+	// corrupted metadata must not be used
+	metadata = []byte(`{"processes":[{"type":"web","command":"evil"}]}`)
+	_, err = getCNBProcessTypes(getter, "home/myapp:git-c3b4e4ba/push/build-metadata.json")
+	assert.True(t, err != nil, "no error received for corrupted metadata")
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, shellQuote("--port=5000"), "--port=5000", "safe word")
+	assert.Equal(t, shellQuote(""), "''", "empty word")
+	assert.Equal(t, shellQuote("$HOME"), "'$HOME'", "variable")
+}
+
+func TestGetCNBProcessTypesMalformed(t *testing.T) {
+	getter := &storage.FakeObjectGetter{
+		Fn: func(ctx context.Context, path string) ([]byte, error) {
+			if path == storage.ChecksumKey("key") {
+				return nil, storagedriver.PathNotFoundError{Path: path}
+			}
+			return []byte("not json"), nil
+		},
+	}
+	_, err := getCNBProcessTypes(getter, "key")
+	assert.True(t, err != nil, "no error received when there should have been")
+}
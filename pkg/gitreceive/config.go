@@ -20,7 +20,11 @@ type Config struct {
 	RegistryPort         string `envconfig:"DEIS_REGISTRY_SERVICE_PORT" required:"true"`
 	RegistryProxyPort    string `envconfig:"DEIS_REGISTRY_PROXY_PORT" default:"5555"`
 	RegistryLocation     string `envconfig:"DEIS_REGISTRY_LOCATION" default:"on-cluster"`
+	RegistryMirrors      string `envconfig:"DEIS_REGISTRY_MIRROR_LOCATIONS" default:""`
 	RegistrySecretPrefix string `envconfig:"DEIS_REGISTRY_SECRET_PREFIX" default:"private-registry"`
+	RegistryHostname     string `envconfig:"DEIS_REGISTRY_HOSTNAME" default:""`
+	RegistryOrganization string `envconfig:"DEIS_REGISTRY_ORGANIZATION" default:""`
+	ObjectKeyLayout      string `envconfig:"OBJECT_KEY_LAYOUT" default:"v2"`
 
 	GitHome                       string `envconfig:"GIT_HOME" required:"true"`
 	SSHConnection                 string `envconfig:"SSH_CONNECTION" required:"true"`
@@ -31,8 +35,11 @@ type Config struct {
 	PodNamespace                  string `envconfig:"POD_NAMESPACE" required:"true"`
 	StorageRegion                 string `envconfig:"STORAGE_REGION" default:"us-east-1"`
 	Debug                         bool   `envconfig:"DEIS_DEBUG" default:"false"`
+	DebugRedactPatterns           string `envconfig:"DEBUG_REDACT_PATTERNS" default:"PASSWORD,PASSWD,SECRET,TOKEN,KEY,AUTH,CREDENTIAL,PRIVATE,USERNAME"`
 	BuilderPodTickDurationMSec    int    `envconfig:"BUILDER_POD_TICK_DURATION" default:"100"`
 	BuilderPodWaitDurationMSec    int    `envconfig:"BUILDER_POD_WAIT_DURATION" default:"900000"` // 15 minutes
+	BuilderPodLogMaxReconnects    int    `envconfig:"BUILDER_POD_LOG_MAX_RECONNECTS" default:"5"`
+	BuilderPodScheduleTimeoutMSec int    `envconfig:"BUILDER_POD_SCHEDULE_TIMEOUT" default:"300000"` // 5 minutes
 	ObjectStorageTickDurationMSec int    `envconfig:"OBJECT_STORAGE_TICK_DURATION" default:"500"`
 	ObjectStorageWaitDurationMSec int    `envconfig:"OBJECT_STORAGE_WAIT_DURATION" default:"300000"` // 5 minutes
 	SessionIdleIntervalMsec       int    `envconfig:"SESSION_IDLE_INTERVAL" default:"10000"`         // 10 seconds
@@ -40,8 +47,29 @@ type Config struct {
 	DockerBuilderImage            string `envconfig:"DOCKERBUILDER_IMAGE_NAME" required:"true"`
 	SlugBuilderImagePullPolicy    string `envconfig:"SLUG_BUILDER_IMAGE_PULL_POLICY" default:"Always"`
 	DockerBuilderImagePullPolicy  string `envconfig:"DOCKER_BUILDER_IMAGE_PULL_POLICY" default:"Always"`
+	DockerBuildBackend            string `envconfig:"DOCKER_BUILD_BACKEND" default:"dockerbuilder"`
+	DockerBuildBackendsAllowed    string `envconfig:"DOCKER_BUILD_BACKENDS_ALLOWED" default:"daemonless"`
+	DaemonlessBuilderImage        string `envconfig:"DAEMONLESS_BUILDER_IMAGE_NAME" default:""`
+	CNBBuilderImage               string `envconfig:"CNB_BUILDER_IMAGE_NAME" default:""`
+	CNBBuilderImagePullPolicy     string `envconfig:"CNB_BUILDER_IMAGE_PULL_POLICY" default:"Always"`
 	StorageType                   string `envconfig:"BUILDER_STORAGE" default:"minio"`
+	StorageRootDirectory          string `envconfig:"BUILDER_STORAGE_ROOT_DIRECTORY" default:"/var/lib/deis/builder/storage"`
+	StorageClaimName              string `envconfig:"BUILDER_STORAGE_CLAIM" default:"deis-builder-storage"`
+	EncryptionKeyID               string `envconfig:"BUILDER_ENCRYPTION_KEY_ID" default:""`
+	EncryptionKeysSecret          string `envconfig:"BUILDER_ENCRYPTION_KEYS_SECRET" default:"builder-encryption-keys"`
+	EncryptionPods                string `envconfig:"BUILDER_ENCRYPTION_PODS" default:""`
 	BuilderPodNodeSelector        string `envconfig:"BUILDER_POD_NODE_SELECTOR" default:""`
+	BuilderPodCPURequest          string `envconfig:"BUILDER_POD_CPU_REQUEST" default:""`
+	BuilderPodCPULimit            string `envconfig:"BUILDER_POD_CPU_LIMIT" default:""`
+	BuilderPodMemoryRequest       string `envconfig:"BUILDER_POD_MEMORY_REQUEST" default:""`
+	BuilderPodMemoryLimit         string `envconfig:"BUILDER_POD_MEMORY_LIMIT" default:""`
+	BuilderPodTolerations         string `envconfig:"BUILDER_POD_TOLERATIONS" default:""`
+	BuilderPodNodeAffinity        string `envconfig:"BUILDER_POD_NODE_AFFINITY" default:""`
+	BuilderPodServiceAccount      string `envconfig:"BUILDER_POD_SERVICE_ACCOUNT" default:""`
+	BuilderPodTolerationsAllowed  string `envconfig:"BUILDER_POD_TOLERATIONS_ALLOWED" default:""`
+	BuilderPodAffinityKeysAllowed string `envconfig:"BUILDER_POD_AFFINITY_KEYS_ALLOWED" default:""`
+	BuilderPodAccountsAllowed     string `envconfig:"BUILDER_POD_SERVICE_ACCOUNTS_ALLOWED" default:""`
+	BuilderPodTemplatePath        string `envconfig:"BUILDER_POD_TEMPLATE_PATH" default:"/etc/deis/builder/pod-template/pod.yaml"`
 }
 
 // App returns the application name represented by c. The app name is the same as c.Repository
@@ -66,6 +94,12 @@ func (c Config) BuilderPodWaitDuration() time.Duration {
 	return time.Duration(time.Duration(c.BuilderPodWaitDurationMSec) * time.Millisecond)
 }
 
+// BuilderPodScheduleTimeout returns the maximum time to wait for a Pod building an application to
+// be scheduled on a node, once the scheduler failed to find one for it.
+func (c Config) BuilderPodScheduleTimeout() time.Duration {
+	return time.Duration(time.Duration(c.BuilderPodScheduleTimeoutMSec) * time.Millisecond)
+}
+
 // ObjectStorageTickDuration returns the size of the interval used to check for
 // the end of an operation that involves the object storage.
 func (c Config) ObjectStorageTickDuration() time.Duration {
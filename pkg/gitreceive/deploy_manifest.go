@@ -0,0 +1,146 @@
+package gitreceive
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/deis/builder/pkg/storage"
+	deisAPI "github.com/deis/controller-sdk-go/api"
+	"github.com/deis/pkg/log"
+	"gopkg.in/yaml.v2"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	// deployManifestName is the file that makes a push deploy an image built elsewhere
+	deployManifestName = "deis.yaml"
+	// deployManifestKey is the app config key enabling deploy manifests for an app, so that a
+	// deis.yaml unrelated to the builder doesn't change how existing apps are built
+	deployManifestKey = "DEIS_DEPLOY_MANIFEST"
+
+	imageCheckTimeout = 30 * time.Second
+)
+
+// deployManifest describes a prebuilt image to deploy, e.g.
+//
+//	image: quay.io/myorg/myapp:1.2.3
+//	processes:
+//	  web: ./server
+//	verify: true
+type deployManifest struct {
+	Image     string            `yaml:"image"`
+	Processes map[string]string `yaml:"processes"`
+	// Verify makes the builder check that the image exists before deploying it
+	Verify bool `yaml:"verify"`
+}
+
+// deployManifestDetector recognizes source trees with a deploy manifest naming an image, in apps
+// that enabled deploy manifests with DEIS_DEPLOY_MANIFEST.
+func deployManifestDetector(dir string, appValues map[string]interface{}) (bool, string) {
+	if enabled, _ := strconv.ParseBool(appValueOrDefault(appValues, deployManifestKey, "")); !enabled {
+		return false, ""
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(dir, deployManifestName))
+	if err != nil {
+		return false, ""
+	}
+	fields := make(map[string]interface{})
+	if err := yaml.Unmarshal(raw, &fields); err != nil {
+		return false, ""
+	}
+	if _, ok := fields["image"]; !ok {
+		return false, ""
+	}
+	return true, fmt.Sprintf("found %s with %s enabled", deployManifestName, deployManifestKey)
+}
+
+// readDeployManifest reads and validates the deploy manifest of the source tree in dir.
+func readDeployManifest(dir string) (*deployManifest, error) {
+	path := filepath.Join(dir, deployManifestName)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error in reading %s (%s)", deployManifestName, err)
+	}
+	manifest := new(deployManifest)
+	if err := yaml.Unmarshal(raw, manifest); err != nil {
+		return nil, fmt.Errorf("%s is malformed (%s)", deployManifestName, err)
+	}
+	if manifest.Image == "" {
+		return nil, fmt.Errorf("%s has no image", deployManifestName)
+	}
+	if _, err := parseImageReference(manifest.Image); err != nil {
+		return nil, fmt.Errorf("%s has an invalid image (%s)", deployManifestName, err)
+	}
+	return manifest, nil
+}
+
+// imageStrategyPod deploys the image of the deploy manifest as is, so it creates no builder pod.
+// If the manifest asks for it, it first checks that the image exists in its registry.
+func imageStrategyPod(b *buildContext) (*api.Pod, string, func(), error) {
+	manifest, err := readDeployManifest(b.dir)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if manifest.Verify {
+		log.Info("Checking that %s exists...", manifest.Image)
+		ref, _ := parseImageReference(manifest.Image)
+		exists, err := imageExists(&http.Client{Timeout: imageCheckTimeout}, "https", ref, imageRegistryCredentials(b, ref))
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("checking image %s (%s)", manifest.Image, err)
+		}
+		if !exists {
+			return nil, "", nil, fmt.Errorf("image %s not found in its registry", manifest.Image)
+		}
+	}
+	log.Info("Deploying prebuilt image %s", manifest.Image)
+	return nil, manifest.Image, nil, nil
+}
+
+// imageRegistryCredentials returns the credentials builder pods push to the registry of ref with,
+// if it's one of the registries the cluster pushes to, so that images of private registries can
+// be verified. Images of other registries are checked anonymously.
+func imageRegistryCredentials(b *buildContext, ref imageReference) registryCredentials {
+	targets, err := registryTargets(b.conf)
+	if err != nil {
+		return registryCredentials{}
+	}
+	for _, target := range targets {
+		if target.location == onClusterRegistry {
+			continue
+		}
+		provider, err := newRegistryProvider(targetRegistryOptions(b, target))
+		if err != nil {
+			continue
+		}
+		creds, err := provider.credentials(b.kubeClient, b.appName)
+		if err != nil {
+			log.Debug("unable to get the credentials of the %s registry (%s)", target.location, err)
+			continue
+		}
+		if canonicalRegistryHost(creds.hostname) == canonicalRegistryHost(ref.registry) {
+			return creds
+		}
+	}
+	return registryCredentials{}
+}
+
+// imageProcTypes returns the process types of the deploy manifest, or the ones of the Procfile of
+// the source tree if the manifest has none.
+func imageProcTypes(b *buildContext, getter storage.ObjectGetter, dir string) (deisAPI.ProcessType, error) {
+	manifest, err := readDeployManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Processes) == 0 {
+		return getProcFile(getter, dir, "", buildTypeImage)
+	}
+	procType := deisAPI.ProcessType{}
+	for name, command := range manifest.Processes {
+		procType[name] = command
+	}
+	return procType, nil
+}
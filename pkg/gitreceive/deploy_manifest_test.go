@@ -0,0 +1,68 @@
+package gitreceive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/deis/builder/pkg/storage"
+)
+
+func writeDeployManifest(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "tmpdir")
+	if err != nil {
+		t.Fatalf("error creating temp directory (%s)", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, deployManifestName), []byte(content), 0644); err != nil {
+		t.Fatalf("error writing %s (%s)", deployManifestName, err)
+	}
+	return dir
+}
+
+func TestImageStrategy(t *testing.T) {
+	dir := writeDeployManifest(t, "image: quay.io/myorg/myapp:1.0\nprocesses:\n  web: ./server\n")
+	defer os.RemoveAll(dir)
+
+	appValues := map[string]interface{}{deployManifestKey: "true"}
+	strategy, reason, err := selectBuildStrategy(dir, appValues)
+	assert.NoErr(t, err)
+	assert.Equal(t, strategy.name, buildTypeImage, "build strategy")
+	assert.Equal(t, reason, "found deis.yaml with DEIS_DEPLOY_MANIFEST enabled", "reason")
+
+	pod, image, cleanup, err := strategy.pod(&buildContext{dir: dir})
+	assert.NoErr(t, err)
+	assert.True(t, pod == nil, "a builder pod was created")
+	assert.True(t, cleanup == nil, "a cleanup func was returned")
+	assert.Equal(t, image, "quay.io/myorg/myapp:1.0", "image")
+
+	procType, err := strategy.procTypes(nil, &storage.FakeObjectGetter{}, dir)
+	assert.NoErr(t, err)
+	assert.Equal(t, len(procType), 1, "number of process types")
+	assert.Equal(t, procType["web"], "./server", "web process")
+}
+
+func TestDeployManifestDetector(t *testing.T) {
+	dir := writeDeployManifest(t, "image: quay.io/myorg/myapp:1.0\n")
+	defer os.RemoveAll(dir)
+	ok, _ := deployManifestDetector(dir, nil)
+	assert.False(t, ok, "deploy manifest detected in an app that didn't enable them")
+	ok, _ = deployManifestDetector(dir, map[string]interface{}{deployManifestKey: "false"})
+	assert.False(t, ok, "deploy manifest detected in an app that disabled them")
+
+	// a deis.yaml used for something else doesn't name an image
+	other := writeDeployManifest(t, "name: myapp\nreplicas: 2\n")
+	defer os.RemoveAll(other)
+	ok, _ = deployManifestDetector(other, map[string]interface{}{deployManifestKey: "true"})
+	assert.False(t, ok, "deis.yaml without image detected as a deploy manifest")
+}
+
+func TestReadDeployManifestInvalid(t *testing.T) {
+	for _, content := range []string{"processes:\n  web: ./server\n", "image: [", "image: quay.io/"} {
+		dir := writeDeployManifest(t, content)
+		_, err := readDeployManifest(dir)
+		os.RemoveAll(dir)
+		assert.True(t, err != nil, "no error for manifest %q", content)
+	}
+}
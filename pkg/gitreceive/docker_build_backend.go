@@ -0,0 +1,159 @@
+package gitreceive
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	// dockerBuildBackendKey is the app config key that selects the Dockerfile build backend of an
+	// app, overriding the cluster wide DOCKER_BUILD_BACKEND with one of the backends allowed in
+	// DOCKER_BUILD_BACKENDS_ALLOWED.
+	dockerBuildBackendKey = "DEIS_DOCKER_BUILD_BACKEND"
+
+	dockerBuildBackendDockerbuilder = "dockerbuilder"
+	dockerBuildBackendDaemonless    = "daemonless"
+
+	daemonlessBuilderName = "deis-daemonlessbuilder"
+	daemonlessStateName   = "daemonless-state"
+	daemonlessStatePath   = "/home/user/.local/share/buildkit"
+	daemonlessUID         = 1000
+)
+
+// dockerBuildPodFunc creates the builder pod of a Dockerfile build. See dockerBuilderPod for the
+// meaning of the arguments.
+type dockerBuildPodFunc func(
+	debug bool,
+	name,
+	namespace string,
+	env map[string]interface{},
+	tarKey,
+	cacheKey,
+	gitSha string,
+	imageName,
+	storageType,
+	image,
+	registryHost,
+	registryPort string,
+	registryEnv map[string]string,
+	pullPolicy api.PullPolicy,
+	nodeSelector map[string]string,
+	template *api.Pod,
+) (*api.Pod, error)
+
+// dockerBuildBackend is a way of building and pushing the image of a Dockerfile app.
+type dockerBuildBackend struct {
+	// image returns the builder image of the backend
+	image func(conf *Config) string
+	// pod creates the builder pod of the backend
+	pod dockerBuildPodFunc
+	// mountsDockerSocket is true if the pods of the backend mount the docker socket of their node
+	mountsDockerSocket bool
+}
+
+// dockerBuildBackends holds every Dockerfile build backend by name.
+var dockerBuildBackends = map[string]dockerBuildBackend{
+	dockerBuildBackendDockerbuilder: {
+		image:              func(conf *Config) string { return conf.DockerBuilderImage },
+		pod:                dockerBuilderPod,
+		mountsDockerSocket: true,
+	},
+	dockerBuildBackendDaemonless: {
+		image: func(conf *Config) string { return conf.DaemonlessBuilderImage },
+		pod:   daemonlessBuilderPod,
+	},
+}
+
+// getDockerBuildBackend returns the Dockerfile build backend selected in the app config, or the
+// cluster wide one if the app doesn't select any. It returns an error if the backend is unknown,
+// if the app selects a backend the operator didn't allow, or if its builder image isn't
+// configured. Apps can never select a backend mounting the docker socket unless it's the cluster
+// wide one, so that a cluster avoiding it for security reasons can't be made to use it.
+func getDockerBuildBackend(conf *Config, appValues map[string]interface{}) (string, dockerBuildBackend, error) {
+	name := appValueOrDefault(appValues, dockerBuildBackendKey, conf.DockerBuildBackend)
+	backend, ok := dockerBuildBackends[name]
+	if !ok {
+		return "", dockerBuildBackend{}, fmt.Errorf("unknown Dockerfile build backend %q", name)
+	}
+	if name != conf.DockerBuildBackend {
+		if backend.mountsDockerSocket || !dockerBuildBackendAllowed(conf, name) {
+			return "", dockerBuildBackend{}, fmt.Errorf("the %s Dockerfile build backend is not allowed on this cluster, unset %s to use %s", name, dockerBuildBackendKey, conf.DockerBuildBackend)
+		}
+	}
+	if backend.image(conf) == "" {
+		return "", dockerBuildBackend{}, fmt.Errorf("no builder image configured for the %s Dockerfile build backend", name)
+	}
+	return name, backend, nil
+}
+
+// dockerBuildBackendAllowed returns true if the operator allows apps to select the named backend.
+func dockerBuildBackendAllowed(conf *Config, name string) bool {
+	for _, allowed := range strings.Split(conf.DockerBuildBackendsAllowed, ",") {
+		if strings.TrimSpace(allowed) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// daemonlessBuilderPod creates a builder pod that builds and pushes the image without a docker
+// daemon, so it doesn't need the docker socket of the node. The builder image follows the same
+// contract as dockerbuilder: it downloads the build context from TAR_PATH and pushes to IMG_NAME
+// using the DEIS_REGISTRY_* credentials, but it runs as an unprivileged user.
+func daemonlessBuilderPod(
+	debug bool,
+	name,
+	namespace string,
+	env map[string]interface{},
+	tarKey,
+	cacheKey,
+	gitSha string,
+	imageName,
+	storageType,
+	image,
+	registryHost,
+	registryPort string,
+	registryEnv map[string]string,
+	pullPolicy api.PullPolicy,
+	nodeSelector map[string]string,
+	template *api.Pod,
+) (*api.Pod, error) {
+
+	pod, err := dockerfileBuildPod(debug, name, namespace, env, tarKey, cacheKey, gitSha, imageName, storageType, image, registryHost, registryPort, registryEnv, pullPolicy, nodeSelector, template)
+	if err != nil {
+		return nil, err
+	}
+	pod.Spec.Containers[0].Name = daemonlessBuilderName
+
+	uid := int64(daemonlessUID)
+	runAsNonRoot := true
+	if pod.Spec.Containers[0].SecurityContext == nil {
+		pod.Spec.Containers[0].SecurityContext = &api.SecurityContext{}
+	}
+	pod.Spec.Containers[0].SecurityContext.RunAsUser = &uid
+	pod.Spec.Containers[0].SecurityContext.RunAsNonRoot = &runAsNonRoot
+
+	// rootless builds need to create their own user namespaces, which the default seccomp and
+	// apparmor profiles forbid
+	if pod.ObjectMeta.Annotations == nil {
+		pod.ObjectMeta.Annotations = make(map[string]string)
+	}
+	pod.ObjectMeta.Annotations["container.apparmor.security.beta.kubernetes.io/"+daemonlessBuilderName] = "unconfined"
+	pod.ObjectMeta.Annotations["container.seccomp.security.alpha.kubernetes.io/"+daemonlessBuilderName] = "unconfined"
+
+	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, api.VolumeMount{
+		Name:      daemonlessStateName,
+		MountPath: daemonlessStatePath,
+	})
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, api.Volume{
+		Name: daemonlessStateName,
+		VolumeSource: api.VolumeSource{
+			EmptyDir: &api.EmptyDirVolumeSource{},
+		},
+	})
+
+	return pod, nil
+}
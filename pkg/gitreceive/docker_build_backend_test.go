@@ -0,0 +1,107 @@
+package gitreceive
+
+import (
+	"testing"
+
+	"github.com/arschles/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestGetDockerBuildBackend(t *testing.T) {
+	conf := &Config{
+		DockerBuildBackend:         dockerBuildBackendDockerbuilder,
+		DockerBuildBackendsAllowed: dockerBuildBackendDaemonless,
+		DockerBuilderImage:         "dockerbuilder",
+		DaemonlessBuilderImage:     "daemonless",
+	}
+
+	name, backend, err := getDockerBuildBackend(conf, nil)
+	assert.NoErr(t, err)
+	assert.Equal(t, name, dockerBuildBackendDockerbuilder, "backend name")
+	assert.Equal(t, backend.image(conf), "dockerbuilder", "backend image")
+
+	appValues := map[string]interface{}{dockerBuildBackendKey: dockerBuildBackendDaemonless}
+	name, backend, err = getDockerBuildBackend(conf, appValues)
+	assert.NoErr(t, err)
+	assert.Equal(t, name, dockerBuildBackendDaemonless, "backend name")
+	assert.Equal(t, backend.image(conf), "daemonless", "backend image")
+
+	appValues = map[string]interface{}{dockerBuildBackendKey: "kaniko"}
+	_, _, err = getDockerBuildBackend(conf, appValues)
+	assert.True(t, err != nil, "no error returned for an unknown backend")
+
+	conf.DaemonlessBuilderImage = ""
+	appValues = map[string]interface{}{dockerBuildBackendKey: dockerBuildBackendDaemonless}
+	_, _, err = getDockerBuildBackend(conf, appValues)
+	assert.True(t, err != nil, "no error returned for a backend without image")
+
+	conf.DaemonlessBuilderImage = "daemonless"
+	conf.DockerBuildBackendsAllowed = ""
+	_, _, err = getDockerBuildBackend(conf, appValues)
+	assert.True(t, err != nil, "no error returned for a backend the operator didn't allow")
+}
+
+func TestGetDockerBuildBackendSocket(t *testing.T) {
+	conf := &Config{
+		DockerBuildBackend:         dockerBuildBackendDaemonless,
+		DockerBuildBackendsAllowed: dockerBuildBackendDockerbuilder,
+		DockerBuilderImage:         "dockerbuilder",
+		DaemonlessBuilderImage:     "daemonless",
+	}
+	// apps can't get the docker socket back on a cluster building without it, even if allowed
+	appValues := map[string]interface{}{dockerBuildBackendKey: dockerBuildBackendDockerbuilder}
+	_, _, err := getDockerBuildBackend(conf, appValues)
+	assert.True(t, err != nil, "no error returned for a backend mounting the docker socket")
+
+	name, _, err := getDockerBuildBackend(conf, map[string]interface{}{dockerBuildBackendKey: dockerBuildBackendDaemonless})
+	assert.NoErr(t, err)
+	assert.Equal(t, name, dockerBuildBackendDaemonless, "backend name")
+}
+
+func TestDaemonlessBuilderPod(t *testing.T) {
+	registryEnv := map[string]string{"DEIS_REGISTRY_USERNAME": "user", "DEIS_REGISTRY_PASSWORD": "pass"}
+	pod, err := daemonlessBuilderPod(
+		false,
+		"test",
+		"default",
+		nil,
+		"home/myapp:git-c3b4e4ba/tar",
+		"home/myapp/docker-cache",
+		"c3b4e4ba",
+		"myapp:git-c3b4e4ba",
+		"minio",
+		"daemonless",
+		"localhost",
+		"5555",
+		registryEnv,
+		api.PullAlways,
+		nil,
+		nil,
+	)
+	assert.NoErr(t, err)
+
+	container := pod.Spec.Containers[0]
+	assert.Equal(t, container.Name, daemonlessBuilderName, "container name")
+	assert.Equal(t, container.Image, "daemonless", "container image")
+	assert.True(t, container.SecurityContext != nil, "no security context")
+	assert.Equal(t, *container.SecurityContext.RunAsUser, int64(daemonlessUID), "run as user")
+	assert.True(t, *container.SecurityContext.RunAsNonRoot, "container may run as root")
+
+	for _, volume := range pod.Spec.Volumes {
+		assert.True(t, volume.HostPath == nil, "volume %s is a host path", volume.Name)
+	}
+	for _, mount := range container.VolumeMounts {
+		assert.False(t, mount.MountPath == dockerSocketPath, "docker socket is mounted")
+	}
+
+	env := make(map[string]string)
+	for _, e := range container.Env {
+		env[e.Name] = e.Value
+	}
+	assert.Equal(t, env[tarPath], "home/myapp:git-c3b4e4ba/tar", "tar path")
+	assert.Equal(t, env["IMG_NAME"], "myapp:git-c3b4e4ba", "image name")
+	assert.Equal(t, env[cachePath], "home/myapp/docker-cache", "cache path")
+	for key, value := range registryEnv {
+		assert.Equal(t, env[key], value, key)
+	}
+}
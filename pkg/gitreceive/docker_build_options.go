@@ -0,0 +1,76 @@
+package gitreceive
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// App config keys tuning the docker build of Dockerfile apps.
+const (
+	// appDockerBuildTargetKey names the stage of a multi-stage Dockerfile to build
+	appDockerBuildTargetKey = "DEIS_DOCKER_BUILD_TARGET"
+	// appDockerBuildLabelsKey lists, comma separated, key=value labels to add to the image
+	appDockerBuildLabelsKey = "DEIS_DOCKER_BUILD_LABELS"
+
+	dockerfilePathEnv    = "DOCKERFILE_PATH"
+	dockerBuildTargetEnv = "DOCKER_BUILD_TARGET"
+	dockerBuildLabelsEnv = "DOCKER_BUILD_LABELS"
+)
+
+var dockerBuildTargetRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*$`)
+
+// dockerBuildOptions holds the options of the docker build of a Dockerfile app, passed to the
+// builder pod as env vars.
+type dockerBuildOptions struct {
+	dockerfile string
+	target     string
+	labels     map[string]string
+}
+
+// newDockerBuildOptions returns the docker build options set in the app config. It returns an
+// error if any of them is invalid.
+func newDockerBuildOptions(appValues map[string]interface{}) (*dockerBuildOptions, error) {
+	dockerfile, err := getDockerfilePath(appValues)
+	if err != nil {
+		return nil, err
+	}
+	opts := &dockerBuildOptions{
+		dockerfile: dockerfile,
+		target:     strings.TrimSpace(appValueOrDefault(appValues, appDockerBuildTargetKey, "")),
+		labels:     make(map[string]string),
+	}
+	if opts.target != "" && !dockerBuildTargetRegex.MatchString(opts.target) {
+		return nil, fmt.Errorf("invalid docker build target %q in %s", opts.target, appDockerBuildTargetKey)
+	}
+	if labels := appValueOrDefault(appValues, appDockerBuildLabelsKey, ""); labels != "" {
+		for _, label := range strings.Split(labels, ",") {
+			kv := strings.SplitN(label, "=", 2)
+			key := strings.TrimSpace(kv[0])
+			if len(kv) != 2 || key == "" {
+				return nil, fmt.Errorf("invalid docker build label %q in %s, must be key=value", label, appDockerBuildLabelsKey)
+			}
+			opts.labels[key] = strings.TrimSpace(kv[1])
+		}
+	}
+	return opts, nil
+}
+
+// env returns the env vars passing the options to the builder pod. Options left to their default
+// are omitted.
+func (o dockerBuildOptions) env() map[string]string {
+	env := make(map[string]string)
+	if o.dockerfile != defaultDockerfile {
+		env[dockerfilePathEnv] = o.dockerfile
+	}
+	if o.target != "" {
+		env[dockerBuildTargetEnv] = o.target
+	}
+	if len(o.labels) > 0 {
+		// passed as a JSON object, like DOCKER_BUILD_ARGS
+		labels, _ := json.Marshal(o.labels)
+		env[dockerBuildLabelsEnv] = string(labels)
+	}
+	return env
+}
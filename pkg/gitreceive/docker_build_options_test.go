@@ -0,0 +1,42 @@
+package gitreceive
+
+import (
+	"testing"
+
+	"github.com/arschles/assert"
+)
+
+func TestNewDockerBuildOptionsDefaults(t *testing.T) {
+	opts, err := newDockerBuildOptions(nil)
+	assert.NoErr(t, err)
+	assert.Equal(t, opts.dockerfile, defaultDockerfile, "Dockerfile path")
+	assert.Equal(t, len(opts.env()), 0, "number of env vars")
+}
+
+func TestNewDockerBuildOptions(t *testing.T) {
+	appValues := map[string]interface{}{
+		appDockerfilePathKey:    "docker/Dockerfile.prod",
+		appDockerBuildTargetKey: "release",
+		appDockerBuildLabelsKey: "team=payments, tier = backend",
+	}
+	opts, err := newDockerBuildOptions(appValues)
+	assert.NoErr(t, err)
+
+	env := opts.env()
+	assert.Equal(t, env[dockerfilePathEnv], "docker/Dockerfile.prod", "Dockerfile path")
+	assert.Equal(t, env[dockerBuildTargetEnv], "release", "build target")
+	assert.Equal(t, env[dockerBuildLabelsEnv], `{"team":"payments","tier":"backend"}`, "build labels")
+}
+
+func TestNewDockerBuildOptionsInvalid(t *testing.T) {
+	invalid := []map[string]interface{}{
+		{appDockerBuildTargetKey: "release stage"},
+		{appDockerBuildLabelsKey: "team"},
+		{appDockerBuildLabelsKey: "=payments"},
+		{appDockerfilePathKey: "../Dockerfile"},
+	}
+	for _, appValues := range invalid {
+		_, err := newDockerBuildOptions(appValues)
+		assert.True(t, err != nil, "no error for %v", appValues)
+	}
+}
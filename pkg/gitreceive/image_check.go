@@ -0,0 +1,218 @@
+package gitreceive
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	dockerHubRegistry    = "registry-1.docker.io"
+	dockerHubLibraryRepo = "library/"
+)
+
+// manifestMediaTypes are the image manifest types accepted when checking that an image exists.
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// imageReference is an image name split into the parts the registry API needs.
+type imageReference struct {
+	registry   string
+	repository string
+	// reference is a tag or a digest
+	reference string
+}
+
+// parseImageReference parses image names such as "myapp", "myorg/myapp:1.0",
+// "quay.io/myorg/myapp:1.0" or "localhost:5000/myapp@sha256:...". Images without registry come
+// from the Docker Hub, and images without tag or digest are tagged latest.
+func parseImageReference(image string) (imageReference, error) {
+	ref := imageReference{registry: dockerHubRegistry, reference: "latest"}
+	name := image
+	if idx := strings.Index(name, "@"); idx >= 0 {
+		ref.reference = name[idx+1:]
+		name = name[:idx]
+	} else if idx := strings.LastIndex(name, ":"); idx > strings.LastIndex(name, "/") {
+		ref.reference = name[idx+1:]
+		name = name[:idx]
+	}
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.registry = parts[0]
+		name = parts[1]
+	} else if !strings.Contains(name, "/") {
+		name = dockerHubLibraryRepo + name
+	}
+	if name == "" || ref.reference == "" || strings.HasSuffix(name, "/") {
+		return ref, fmt.Errorf("invalid image name %q", image)
+	}
+	ref.repository = name
+	return ref, nil
+}
+
+// canonicalRegistryHost returns the host of a registry hostname, with the aliases of the Docker
+// Hub replaced by the host of its registry API, so that hostnames of the same registry match.
+func canonicalRegistryHost(hostname string) string {
+	switch host := registryHost(hostname); host {
+	case "", "docker.io", "index.docker.io":
+		return dockerHubRegistry
+	default:
+		return host
+	}
+}
+
+// imageExists asks the registry of ref whether the manifest of ref exists, using the registry v2
+// API. The registry is authenticated to with creds, or anonymously if they're empty, so that
+// only public images or registries allowing anonymous pulls can be checked without credentials.
+func imageExists(client *http.Client, scheme string, ref imageReference, creds registryCredentials) (bool, error) {
+	manifestURL := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, ref.registry, ref.repository, ref.reference)
+	res, err := headManifest(client, manifestURL, "")
+	if err != nil {
+		return false, err
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		authorization, err := registryAuthorization(client, res.Header.Get("Www-Authenticate"), creds)
+		if err != nil {
+			return false, err
+		}
+		if res, err = headManifest(client, manifestURL, authorization); err != nil {
+			return false, err
+		}
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("registry %s returned %s", ref.registry, res.Status)
+	}
+}
+
+func headManifest(client *http.Client, manifestURL, authorization string) (*http.Response, error) {
+	req, err := http.NewRequest("HEAD", manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return res, nil
+}
+
+// registryAuthorization returns the Authorization header answering the Www-Authenticate challenge
+// of a registry with creds.
+func registryAuthorization(client *http.Client, challenge string, creds registryCredentials) (string, error) {
+	switch {
+	case strings.HasPrefix(challenge, "Bearer "):
+		token, err := registryToken(client, challenge, creds)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	case strings.HasPrefix(challenge, "Basic ") && creds.username != "":
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds.username+":"+creds.password)), nil
+	default:
+		return "", fmt.Errorf("registry requires unsupported authentication %q", challenge)
+	}
+}
+
+// parseChallengeParams returns the auth params of a Bearer Www-Authenticate challenge, such as
+// `Bearer realm="https://auth.docker.io/token",scope="repository:a:pull,push"`. Values are tokens
+// or quoted strings, which may hold commas and backslash escaped characters.
+func parseChallengeParams(challenge string) map[string]string {
+	params := make(map[string]string)
+	rest := strings.TrimSpace(challenge)
+	if idx := strings.IndexAny(rest, " \t"); idx >= 0 && strings.EqualFold(rest[:idx], "Bearer") {
+		rest = rest[idx+1:]
+	}
+	for {
+		rest = strings.TrimLeft(rest, " \t,")
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimLeft(rest[eq+1:], " \t")
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			var b bytes.Buffer
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			if i < len(rest) {
+				i++
+			}
+			value, rest = b.String(), rest[i:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value, rest = strings.TrimSpace(rest[:end]), rest[end:]
+		}
+		params[key] = value
+	}
+}
+
+// registryToken gets a token from the auth server described by the Www-Authenticate challenge of
+// a registry, authenticating with creds unless they're empty.
+func registryToken(client *http.Client, challenge string, creds registryCredentials) (string, error) {
+	params := parseChallengeParams(challenge)
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("registry authentication challenge %q has no valid realm", challenge)
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if creds.username != "" {
+		req.SetBasicAuth(creds.username, creds.password)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry auth server %s returned %s", realm.Host, res.Status)
+	}
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("decoding registry token (%s)", err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
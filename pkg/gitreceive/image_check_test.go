@@ -0,0 +1,129 @@
+package gitreceive
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/arschles/assert"
+)
+
+func TestParseImageReference(t *testing.T) {
+	cases := []struct {
+		image string
+		ref   imageReference
+	}{
+		{"myapp", imageReference{dockerHubRegistry, "library/myapp", "latest"}},
+		{"myorg/myapp:1.0", imageReference{dockerHubRegistry, "myorg/myapp", "1.0"}},
+		{"quay.io/myorg/myapp:1.0", imageReference{"quay.io", "myorg/myapp", "1.0"}},
+		{"localhost:5000/myapp", imageReference{"localhost:5000", "myapp", "latest"}},
+		{"localhost/myapp@sha256:abc", imageReference{"localhost", "myapp", "sha256:abc"}},
+	}
+	for _, c := range cases {
+		ref, err := parseImageReference(c.image)
+		assert.NoErr(t, err)
+		assert.Equal(t, ref, c.ref, c.image)
+	}
+
+	for _, image := range []string{"myapp:", "quay.io/", "myapp@"} {
+		_, err := parseImageReference(image)
+		assert.True(t, err != nil, "no error for invalid image %s", image)
+	}
+}
+
+func TestImageExists(t *testing.T) {
+	var authServerURL string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		// the repository is private
+		if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("scope") != "repository:myorg/myapp:pull" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"token":"secret"}`)
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:myorg/myapp:pull"`, authServerURL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/v2/myorg/myapp/manifests/1.0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	authServerURL = server.URL
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	creds := registryCredentials{hostname: registry, username: "user", password: "pass"}
+	exists, err := imageExists(http.DefaultClient, "http", imageReference{registry, "myorg/myapp", "1.0"}, creds)
+	assert.NoErr(t, err)
+	assert.True(t, exists, "image doesn't exist")
+
+	exists, err = imageExists(http.DefaultClient, "http", imageReference{registry, "myorg/myapp", "2.0"}, creds)
+	assert.NoErr(t, err)
+	assert.False(t, exists, "image exists")
+
+	_, err = imageExists(http.DefaultClient, "http", imageReference{registry, "myorg/myapp", "1.0"}, registryCredentials{})
+	assert.True(t, err != nil, "no error for an anonymous check of a private image")
+}
+
+func TestImageExistsBasicAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "pass" {
+			w.Header().Set("Www-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	ref := imageReference{strings.TrimPrefix(server.URL, "http://"), "myapp", "latest"}
+	exists, err := imageExists(http.DefaultClient, "http", ref, registryCredentials{username: "user", password: "pass"})
+	assert.NoErr(t, err)
+	assert.True(t, exists, "image doesn't exist")
+}
+
+func TestCanonicalRegistryHost(t *testing.T) {
+	assert.Equal(t, canonicalRegistryHost("https://index.docker.io/v1/"), dockerHubRegistry, "docker hub host")
+	assert.Equal(t, canonicalRegistryHost(""), dockerHubRegistry, "docker hub host")
+	assert.Equal(t, canonicalRegistryHost("https://123456789012.dkr.ecr.us-east-1.amazonaws.com"), "123456789012.dkr.ecr.us-east-1.amazonaws.com", "ecr host")
+}
+
+func TestImageExistsUnsupportedAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Www-Authenticate", `Basic realm="registry"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	ref := imageReference{strings.TrimPrefix(server.URL, "http://"), "myapp", "latest"}
+	_, err := imageExists(http.DefaultClient, "http", ref, registryCredentials{})
+	assert.True(t, err != nil, "no error for unsupported authentication")
+}
+
+func TestParseChallengeParams(t *testing.T) {
+	params := parseChallengeParams(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:myorg/myapp:pull,push"`)
+	expected := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:myorg/myapp:pull,push",
+	}
+	assert.Equal(t, params, expected, "params")
+
+	params = parseChallengeParams(`bearer realm="https://auth.example.com/token", error=insufficient_scope, note="a \"quoted\" word"`)
+	assert.Equal(t, params["realm"], "https://auth.example.com/token", "realm")
+	assert.Equal(t, params["error"], "insufficient_scope", "token value")
+	assert.Equal(t, params["note"], `a "quoted" word`, "escaped value")
+}
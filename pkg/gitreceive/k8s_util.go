@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/deis/builder/pkg/k8s"
+	"github.com/deis/builder/pkg/storage"
 	"github.com/pborman/uuid"
 	"k8s.io/kubernetes/pkg/api"
 	apierrors "k8s.io/kubernetes/pkg/api/errors"
@@ -19,7 +20,10 @@ const (
 	dockerBuilderName = "deis-dockerbuilder"
 
 	tarPath          = "TAR_PATH"
+	tarChecksumEnv   = "TAR_SHA256"
 	putPath          = "PUT_PATH"
+	putChecksum      = "PUT_CHECKSUM_SUFFIX"
+	slugDigestPath   = "SLUG_DIGEST_PATH"
 	cachePath        = "CACHE_PATH"
 	debugKey         = "DEIS_DEBUG"
 	sourceVersion    = "SOURCE_VERSION"
@@ -57,7 +61,8 @@ func dockerBuilderPod(
 	namespace string,
 	env map[string]interface{},
 	tarKey,
-	gitShortHash string,
+	cacheKey,
+	gitSha string,
 	imageName,
 	storageType,
 	image,
@@ -66,9 +71,58 @@ func dockerBuilderPod(
 	registryEnv map[string]string,
 	pullPolicy api.PullPolicy,
 	nodeSelector map[string]string,
-) *api.Pod {
+	template *api.Pod,
+) (*api.Pod, error) {
+
+	pod, err := dockerfileBuildPod(debug, name, namespace, env, tarKey, cacheKey, gitSha, imageName, storageType, image, registryHost, registryPort, registryEnv, pullPolicy, nodeSelector, template)
+	if err != nil {
+		return nil, err
+	}
+	pod.Spec.Containers[0].Name = dockerBuilderName
+
+	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, api.VolumeMount{
+		Name:      dockerSocketName,
+		MountPath: dockerSocketPath,
+	})
+
+	pod.Spec.Volumes = append(pod.Spec.Volumes, api.Volume{
+		Name: dockerSocketName,
+		VolumeSource: api.VolumeSource{
+			HostPath: &api.HostPathVolumeSource{
+				Path: dockerSocketPath,
+			},
+		},
+	})
 
-	pod := buildPod(debug, name, namespace, pullPolicy, nodeSelector, env)
+	return pod, nil
+}
+
+// dockerfileBuildPod creates the parts of a builder pod common to every Dockerfile build backend:
+// the build context, layer cache and registry details are passed to the builder container as env
+// vars.
+func dockerfileBuildPod(
+	debug bool,
+	name,
+	namespace string,
+	env map[string]interface{},
+	tarKey,
+	cacheKey,
+	gitSha string,
+	imageName,
+	storageType,
+	image,
+	registryHost,
+	registryPort string,
+	registryEnv map[string]string,
+	pullPolicy api.PullPolicy,
+	nodeSelector map[string]string,
+	template *api.Pod,
+) (*api.Pod, error) {
+
+	pod, err := buildPod(debug, name, namespace, pullPolicy, nodeSelector, env, template)
+	if err != nil {
+		return nil, err
+	}
 
 	// inject application envvars as a special envvar which will be handled by dockerbuilder to
 	// inject them as build-time variables.
@@ -82,11 +136,15 @@ func dockerBuilderPod(
 		addEnvToPod(pod, "DOCKER_BUILD_ARGS", string(dockerBuildArgs))
 	}
 
-	pod.Spec.Containers[0].Name = dockerBuilderName
 	pod.Spec.Containers[0].Image = image
 
+	// If cacheKey is set, the builder imports the layer cache from it and exports it back
+	if cacheKey != "" {
+		addEnvToPod(pod, cachePath, cacheKey)
+	}
+
 	addEnvToPod(pod, tarPath, tarKey)
-	addEnvToPod(pod, sourceVersion, gitShortHash)
+	addEnvToPod(pod, sourceVersion, gitSha)
 	addEnvToPod(pod, "IMG_NAME", imageName)
 	addEnvToPod(pod, builderStorage, storageType)
 	// inject existing DEIS_REGISTRY_SERVICE_HOST and PORT info to dockerbuilder
@@ -98,21 +156,7 @@ func dockerBuilderPod(
 		addEnvToPod(pod, key, value)
 	}
 
-	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, api.VolumeMount{
-		Name:      dockerSocketName,
-		MountPath: dockerSocketPath,
-	})
-
-	pod.Spec.Volumes = append(pod.Spec.Volumes, api.Volume{
-		Name: dockerSocketName,
-		VolumeSource: api.VolumeSource{
-			HostPath: &api.HostPathVolumeSource{
-				Path: dockerSocketPath,
-			},
-		},
-	})
-
-	return &pod
+	return &pod, nil
 }
 
 func slugbuilderPod(
@@ -123,15 +167,19 @@ func slugbuilderPod(
 	tarKey,
 	putKey,
 	cacheKey,
-	gitShortHash string,
+	gitSha string,
 	buildpackURL,
 	storageType,
 	image string,
 	pullPolicy api.PullPolicy,
 	nodeSelector map[string]string,
-) *api.Pod {
+	template *api.Pod,
+) (*api.Pod, error) {
 
-	pod := buildPod(debug, name, namespace, pullPolicy, nodeSelector, nil)
+	pod, err := buildPod(debug, name, namespace, pullPolicy, nodeSelector, nil, template)
+	if err != nil {
+		return nil, err
+	}
 
 	pod.Spec.Volumes = append(pod.Spec.Volumes, api.Volume{
 		Name: envSecretName,
@@ -158,41 +206,51 @@ func slugbuilderPod(
 
 	addEnvToPod(pod, tarPath, tarKey)
 	addEnvToPod(pod, putPath, putKey)
-	addEnvToPod(pod, sourceVersion, gitShortHash)
+	// the slugbuilder uploads the hex encoded sha256 of every object it puts under PUT_PATH, such as
+	// the Procfile, next to it with PUT_CHECKSUM_SUFFIX appended to its key, so the builder can verify
+	// it. The one of the slug is at SLUG_DIGEST_PATH.
+	addEnvToPod(pod, putChecksum, storage.ChecksumSuffix)
+	addEnvToPod(pod, slugDigestPath, storage.ChecksumKey(putKey+"/"+slugTGZName))
+	addEnvToPod(pod, sourceVersion, gitSha)
 	addEnvToPod(pod, builderStorage, storageType)
 
 	if buildpackURL != "" {
 		addEnvToPod(pod, "BUILDPACK_URL", buildpackURL)
 	}
 
-	return &pod
+	return &pod, nil
 }
 
+// buildPod creates the parts of a builder pod common to slugbuilder and dockerbuilder. If template
+// is not nil, the pod is merged into a copy of it: the first container of the template becomes
+// the builder container, the rest of its containers, volumes, labels and annotations are kept.
+// It returns an error if the template can't be copied, so that the build doesn't run without it.
 func buildPod(
 	debug bool,
 	name,
 	namespace string,
 	pullPolicy api.PullPolicy,
 	nodeSelector map[string]string,
-	env map[string]interface{}) api.Pod {
-
-	pod := api.Pod{
-		Spec: api.PodSpec{
-			RestartPolicy: api.RestartPolicyNever,
-			Containers: []api.Container{
-				{
-					ImagePullPolicy: pullPolicy,
-				},
-			},
-			Volumes: []api.Volume{},
-		},
-		ObjectMeta: api.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
-			Labels: map[string]string{
-				"heritage": name,
-			},
-		},
+	env map[string]interface{},
+	template *api.Pod) (api.Pod, error) {
+
+	pod, err := copyPodTemplate(template)
+	if err != nil {
+		return pod, err
+	}
+	pod.ObjectMeta.Name = name
+	pod.ObjectMeta.Namespace = namespace
+	if pod.ObjectMeta.Labels == nil {
+		pod.ObjectMeta.Labels = make(map[string]string)
+	}
+	pod.ObjectMeta.Labels["heritage"] = name
+	pod.Spec.RestartPolicy = api.RestartPolicyNever
+	if len(pod.Spec.Containers) == 0 {
+		pod.Spec.Containers = []api.Container{{}}
+	}
+	pod.Spec.Containers[0].ImagePullPolicy = pullPolicy
+	if pod.Spec.Volumes == nil {
+		pod.Spec.Volumes = []api.Volume{}
 	}
 
 	pod.Spec.Volumes = append(pod.Spec.Volumes, api.Volume{
@@ -204,13 +262,11 @@ func buildPod(
 		},
 	})
 
-	pod.Spec.Containers[0].VolumeMounts = []api.VolumeMount{
-		{
-			Name:      objectStore,
-			MountPath: objectStorePath,
-			ReadOnly:  true,
-		},
-	}
+	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, api.VolumeMount{
+		Name:      objectStore,
+		MountPath: objectStorePath,
+		ReadOnly:  true,
+	})
 
 	if len(pod.Spec.Containers) > 0 {
 		for k, v := range env {
@@ -222,14 +278,19 @@ func buildPod(
 	}
 
 	if len(nodeSelector) > 0 {
-		pod.Spec.NodeSelector = nodeSelector
+		if pod.Spec.NodeSelector == nil {
+			pod.Spec.NodeSelector = make(map[string]string)
+		}
+		for k, v := range nodeSelector {
+			pod.Spec.NodeSelector[k] = v
+		}
 	}
 
 	if debug {
 		addEnvToPod(pod, debugKey, "1")
 	}
 
-	return pod
+	return pod, nil
 }
 
 func addEnvToPod(pod api.Pod, key, value string) {
@@ -241,8 +302,11 @@ func addEnvToPod(pod api.Pod, key, value string) {
 	}
 }
 
-// waitForPod waits for a pod in state running, succeeded or failed
-func waitForPod(pw *k8s.PodWatcher, ns, podName string, ticker, interval, timeout time.Duration) error {
+// waitForPod waits for a pod in state running, succeeded or failed. While the pod is pending, the
+// reasons it isn't running yet are shown to the user, and waiting stops early if the pod will
+// never start, or if it failed to be scheduled for schedulingTimeout.
+func waitForPod(pw *k8s.PodWatcher, ns, podName string, events podEventLister, ticker, interval, timeout, schedulingTimeout time.Duration) error {
+	reporter := newPodStatusReporter(events, schedulingTimeout)
 	condition := func(pod *api.Pod) (bool, error) {
 		if pod.Status.Phase == api.PodRunning {
 			return true, nil
@@ -253,6 +317,9 @@ func waitForPod(pw *k8s.PodWatcher, ns, podName string, ticker, interval, timeou
 		if pod.Status.Phase == api.PodFailed {
 			return true, fmt.Errorf("Giving up; pod went into failed status: \n[%s]:%s", pod.Status.Reason, pod.Status.Message)
 		}
+		if err := reporter.report(pod); err != nil {
+			return true, err
+		}
 		return false, nil
 	}
 
@@ -263,21 +330,31 @@ func waitForPod(pw *k8s.PodWatcher, ns, podName string, ticker, interval, timeou
 	return err
 }
 
-// waitForPodEnd waits for a pod in state succeeded or failed
-func waitForPodEnd(pw *k8s.PodWatcher, ns, podName string, interval, timeout time.Duration) error {
+// waitForPodEnd waits for a pod in state succeeded or failed, or whose builder container, named
+// containerName, terminated. Sidecar containers from the pod template may keep the pod running
+// after the build ended.
+func waitForPodEnd(pw *k8s.PodWatcher, ns, podName, containerName string, interval, timeout time.Duration) error {
 	condition := func(pod *api.Pod) (bool, error) {
-		if pod.Status.Phase == api.PodSucceeded {
-			return true, nil
-		}
-		if pod.Status.Phase == api.PodFailed {
-			return true, nil
-		}
-		return false, nil
+		return podEnded(pod, containerName), nil
 	}
 
 	return waitForPodCondition(pw, ns, podName, condition, interval, timeout)
 }
 
+// podEnded returns true if pod is in state succeeded or failed, or if its container named
+// containerName terminated.
+func podEnded(pod *api.Pod, containerName string) bool {
+	if pod.Status.Phase == api.PodSucceeded || pod.Status.Phase == api.PodFailed {
+		return true
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == containerName && status.State.Terminated != nil {
+			return true
+		}
+	}
+	return false
+}
+
 // waitForPodCondition waits for a pod in state defined by a condition (func)
 func waitForPodCondition(pw *k8s.PodWatcher, ns, podName string, condition func(pod *api.Pod) (bool, error),
 	interval, timeout time.Duration) error {
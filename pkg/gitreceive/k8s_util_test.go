@@ -64,6 +64,7 @@ type dockerBuildCase struct {
 	namespace                    string
 	env                          map[string]interface{}
 	tarKey                       string
+	cacheKey                     string
 	gitShortHash                 string
 	imgName                      string
 	dockerBuilderImage           string
@@ -82,6 +83,7 @@ func TestBuildPod(t *testing.T) {
 	buildArgsEnv["KEY"] = "VALUE"
 	envSecretName := "test-build-env"
 	var pod *api.Pod
+	var err error
 
 	emptyNodeSelector := make(map[string]string)
 
@@ -104,7 +106,7 @@ func TestBuildPod(t *testing.T) {
 	}
 
 	for _, build := range slugBuilds {
-		pod = slugbuilderPod(
+		pod, err = slugbuilderPod(
 			build.debug,
 			build.name,
 			build.namespace,
@@ -118,7 +120,9 @@ func TestBuildPod(t *testing.T) {
 			build.slugBuilderImage,
 			build.slugBuilderImagePullPolicy,
 			build.builderPodNodeSelector,
+			nil,
 		)
+		assert.NoErr(t, err)
 
 		if pod.ObjectMeta.Name != build.name {
 			t.Errorf("expected %v but returned %v ", build.name, pod.ObjectMeta.Name)
@@ -131,6 +135,8 @@ func TestBuildPod(t *testing.T) {
 		checkForEnv(t, pod, "SOURCE_VERSION", build.gitShortHash)
 		checkForEnv(t, pod, "TAR_PATH", build.tarKey)
 		checkForEnv(t, pod, "PUT_PATH", build.putKey)
+		checkForEnv(t, pod, "PUT_CHECKSUM_SUFFIX", ".sha256")
+		checkForEnv(t, pod, "SLUG_DIGEST_PATH", build.putKey+"/slug.tgz.sha256")
 
 		if build.cacheKey == "" {
 			if cachePath, err := envValueFromKey(pod, "CACHE_PATH"); err == nil {
@@ -161,23 +167,24 @@ func TestBuildPod(t *testing.T) {
 	}
 
 	dockerBuilds := []dockerBuildCase{
-		{true, "test", "default", emptyEnv, "tar", "deadbeef", "", "", api.PullAlways, "", nodeSelector1},
-		{true, "test", "default", env, "tar", "deadbeef", "", "", api.PullAlways, "", nodeSelector2},
-		{true, "test", "default", emptyEnv, "tar", "deadbeef", "img", "", api.PullAlways, "", emptyNodeSelector},
-		{true, "test", "default", env, "tar", "deadbeef", "img", "", api.PullAlways, "", emptyNodeSelector},
-		{true, "test", "default", env, "tar", "deadbeef", "img", "customimage", api.PullAlways, "", emptyNodeSelector},
-		{true, "test", "default", env, "tar", "deadbeef", "img", "customimage", api.PullIfNotPresent, "", emptyNodeSelector},
-		{true, "test", "default", env, "tar", "deadbeef", "img", "customimage", api.PullNever, "", nil},
-		{true, "test", "default", buildArgsEnv, "tar", "deadbeef", "img", "customimage", api.PullIfNotPresent, "", emptyNodeSelector},
+		{true, "test", "default", emptyEnv, "tar", "cache-url", "deadbeef", "", "", api.PullAlways, "", nodeSelector1},
+		{true, "test", "default", env, "tar", "", "deadbeef", "", "", api.PullAlways, "", nodeSelector2},
+		{true, "test", "default", emptyEnv, "tar", "cache-url", "deadbeef", "img", "", api.PullAlways, "", emptyNodeSelector},
+		{true, "test", "default", env, "tar", "", "deadbeef", "img", "", api.PullAlways, "", emptyNodeSelector},
+		{true, "test", "default", env, "tar", "cache-url", "deadbeef", "img", "customimage", api.PullAlways, "", emptyNodeSelector},
+		{true, "test", "default", env, "tar", "", "deadbeef", "img", "customimage", api.PullIfNotPresent, "", emptyNodeSelector},
+		{true, "test", "default", env, "tar", "cache-url", "deadbeef", "img", "customimage", api.PullNever, "", nil},
+		{true, "test", "default", buildArgsEnv, "tar", "", "deadbeef", "img", "customimage", api.PullIfNotPresent, "", emptyNodeSelector},
 	}
 	regEnv := map[string]string{"REG_LOC": "on-cluster"}
 	for _, build := range dockerBuilds {
-		pod = dockerBuilderPod(
+		pod, err = dockerBuilderPod(
 			build.debug,
 			build.name,
 			build.namespace,
 			build.env,
 			build.tarKey,
+			build.cacheKey,
 			build.gitShortHash,
 			build.imgName,
 			build.storageType,
@@ -187,7 +194,9 @@ func TestBuildPod(t *testing.T) {
 			regEnv,
 			build.dockerBuilderImagePullPolicy,
 			build.builderPodNodeSelector,
+			nil,
 		)
+		assert.NoErr(t, err)
 
 		if pod.ObjectMeta.Name != build.name {
 			t.Errorf("expected %v but returned %v ", build.name, pod.ObjectMeta.Name)
@@ -200,6 +209,13 @@ func TestBuildPod(t *testing.T) {
 		checkForEnv(t, pod, "TAR_PATH", build.tarKey)
 		checkForEnv(t, pod, "IMG_NAME", build.imgName)
 		checkForEnv(t, pod, "REG_LOC", "on-cluster")
+		if build.cacheKey == "" {
+			if cachePath, err := envValueFromKey(pod, "CACHE_PATH"); err == nil {
+				t.Errorf("expected CACHE_PATH not to be defined but it was defined with %v", cachePath)
+			}
+		} else {
+			checkForEnv(t, pod, "CACHE_PATH", build.cacheKey)
+		}
 		if _, ok := build.env["DEIS_DOCKER_BUILD_ARGS_ENABLED"]; ok {
 			checkForEnv(t, pod, "DOCKER_BUILD_ARGS", `{"DEIS_DOCKER_BUILD_ARGS_ENABLED":"1","KEY":"VALUE"}`)
 		}
@@ -242,6 +258,27 @@ func envValueFromKey(pod *api.Pod, key string) (string, error) {
 	return "", fmt.Errorf("no key with name %v found in pod env", key)
 }
 
+func TestPodEnded(t *testing.T) {
+	pod := &api.Pod{
+		Status: api.PodStatus{
+			Phase: api.PodRunning,
+			ContainerStatuses: []api.ContainerStatus{
+				{Name: "log-shipper", State: api.ContainerState{Running: &api.ContainerStateRunning{}}},
+				{Name: slugBuilderName, State: api.ContainerState{Running: &api.ContainerStateRunning{}}},
+			},
+		},
+	}
+	assert.False(t, podEnded(pod, slugBuilderName), "pod ended while the builder container runs")
+
+	// a sidecar still running keeps the pod running once the builder container terminated
+	pod.Status.ContainerStatuses[1].State = api.ContainerState{Terminated: &api.ContainerStateTerminated{ExitCode: 0}}
+	assert.True(t, podEnded(pod, slugBuilderName), "pod not ended after the builder container terminated")
+	assert.False(t, podEnded(pod, "log-shipper"), "pod ended while the sidecar runs")
+
+	pod = &api.Pod{Status: api.PodStatus{Phase: api.PodFailed}}
+	assert.True(t, podEnded(pod, slugBuilderName), "failed pod not ended")
+}
+
 func TestCreateAppEnvConfigSecretErr(t *testing.T) {
 	expectedErr := errors.New("get secret error")
 	secretsClient := &k8s.FakeSecret{
@@ -0,0 +1,201 @@
+package gitreceive
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/deis/pkg/log"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// podLogStreamer opens a stream of the logs of a builder pod with the given options.
+type podLogStreamer func(opts *api.PodLogOptions) (io.ReadCloser, error)
+
+// podTerminatedChecker returns true if the builder pod has stopped running.
+type podTerminatedChecker func() bool
+
+// kubePodLogStreamer returns a podLogStreamer that streams the logs of the given container from
+// the kubernetes API.
+func kubePodLogStreamer(kubeClient *client.Client, ns, podName, containerName string) podLogStreamer {
+	return func(opts *api.PodLogOptions) (io.ReadCloser, error) {
+		// the builder pod may have sidecar containers coming from the pod template
+		opts.Container = containerName
+		return kubeClient.Get().Namespace(ns).Name(podName).Resource("pods").SubResource("log").VersionedParams(
+			opts, api.ParameterCodec).Stream()
+	}
+}
+
+// kubePodTerminatedChecker returns a podTerminatedChecker that gets the pod from the kubernetes
+// API, rather than from the store of a pod watcher, which may not have seen the pod terminate yet
+// when its log stream ends. The pod has stopped running when its phase is terminal, or when the
+// given container has terminated, since sidecar containers may keep the pod running.
+func kubePodTerminatedChecker(kubeClient *client.Client, ns, podName, containerName string) podTerminatedChecker {
+	return func() bool {
+		pod, err := kubeClient.Pods(ns).Get(podName)
+		if err != nil {
+			log.Debug("getting builder pod %s (%s)", podName, err)
+			return false
+		}
+		return podEnded(pod, containerName)
+	}
+}
+
+// podTerminationPollInterval is how often followPodLogs checks whether the builder pod terminated
+// after its log stream ended, since the pod status may lag behind the end of its logs.
+const podTerminationPollInterval = 500 * time.Millisecond
+
+// podLogCursor is the position of followPodLogs in the logs of a builder pod: the timestamp of the
+// last line it wrote and the number of lines written with that timestamp. A reopened stream starts
+// at lastSeen, so it replays the lines written at lastSeen, which the cursor skips, but lines
+// sharing a timestamp in the same stream are all written.
+type podLogCursor struct {
+	lastSeen   time.Time
+	atLastSeen int
+	// replaying is true while the current stream replays lines already written, and toSkip is
+	// the number of lines at lastSeen it still replays
+	replaying bool
+	toSkip    int
+}
+
+// reopen tells c the stream was reopened from lastSeen.
+func (c *podLogCursor) reopen() {
+	c.replaying = true
+	c.toSkip = c.atLastSeen
+}
+
+// skip returns true if a line with timestamp ts was already written, and moves c past it otherwise.
+func (c *podLogCursor) skip(ts time.Time) bool {
+	if c.replaying {
+		if ts.Before(c.lastSeen) {
+			return true
+		}
+		if ts.Equal(c.lastSeen) && c.toSkip > 0 {
+			c.toSkip--
+			return true
+		}
+		c.replaying = false
+	}
+	switch {
+	case ts.After(c.lastSeen):
+		c.lastSeen = ts
+		c.atLastSeen = 1
+	case ts.Equal(c.lastSeen):
+		c.atLastSeen++
+	}
+	return false
+}
+
+// followPodLogs copies the logs of a builder pod to w until the pod terminates. If the log stream
+// ends while the pod is still running after retryInterval, or can't be opened, it reopens the
+// stream from the time of the last line it received, so that no line is shown twice. It gives up
+// after maxReconnects reconnections. Returns the number of bytes written to w.
+func followPodLogs(
+	w io.Writer,
+	stream podLogStreamer,
+	terminated podTerminatedChecker,
+	maxReconnects int,
+	retryInterval time.Duration) (int64, error) {
+
+	var written int64
+	cursor := new(podLogCursor)
+	for reconnects := 0; ; reconnects++ {
+		opts := &api.PodLogOptions{Follow: true, Timestamps: true}
+		if !cursor.lastSeen.IsZero() {
+			sinceTime := unversioned.NewTime(cursor.lastSeen)
+			opts.SinceTime = &sinceTime
+			cursor.reopen()
+		}
+		rc, err := stream(opts)
+		if err != nil {
+			// the logs of a terminated pod can still be streamed, so the pod isn't checked here
+			if reconnects >= maxReconnects {
+				return written, fmt.Errorf("attempting to stream logs (%s)", err)
+			}
+			log.Info("Unable to stream the build logs, retrying (%d/%d)...", reconnects+1, maxReconnects)
+			log.Debug("attempting to stream logs (%s)", err)
+			time.Sleep(retryInterval)
+			continue
+		}
+		n, partial, err := copyPodLogs(w, rc, cursor)
+		rc.Close()
+		written += n
+		if err != nil {
+			log.Debug("builder pod log stream ended with error (%s)", err)
+		}
+
+		// a line cut off by a dropped stream is sent whole by the next one, since the cursor wasn't
+		// moved past it. If the pod terminated, it's the last line of the logs.
+		if waitPodTerminated(terminated, retryInterval) {
+			n, err := writePodLogLine(w, partial, cursor)
+			return written + int64(n), err
+		}
+		if reconnects >= maxReconnects {
+			return written, fmt.Errorf("builder pod log stream dropped %d times, giving up", reconnects+1)
+		}
+		log.Info("Lost connection to the build log stream, reconnecting (%d/%d)...", reconnects+1, maxReconnects)
+	}
+}
+
+// waitPodTerminated checks every podTerminationPollInterval, for up to timeout, whether the builder
+// pod terminated. Returns true as soon as it did.
+func waitPodTerminated(terminated podTerminatedChecker, timeout time.Duration) bool {
+	interval := podTerminationPollInterval
+	if timeout < interval {
+		interval = timeout
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		if terminated() {
+			return true
+		}
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(interval)
+	}
+}
+
+// copyPodLogs copies timestamped log lines from r to w, stripping their timestamps. Lines the
+// cursor knows were already written by a previous stream are skipped, and the cursor is moved past
+// each line written. A last line not ended by a newline may have been cut off by a dropped stream:
+// it's returned rather than written.
+func copyPodLogs(w io.Writer, r io.Reader, cursor *podLogCursor) (int64, []byte, error) {
+	var written int64
+	reader := bufio.NewReader(r)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr == io.EOF {
+			return written, line, nil
+		}
+		n, err := writePodLogLine(w, line, cursor)
+		written += int64(n)
+		if err != nil {
+			return written, nil, err
+		}
+		if readErr != nil {
+			return written, nil, readErr
+		}
+	}
+}
+
+// writePodLogLine writes line to w without its timestamp, unless cursor knows it was already
+// written. Lines without a timestamp are written as is.
+func writePodLogLine(w io.Writer, line []byte, cursor *podLogCursor) (int, error) {
+	if len(line) == 0 {
+		return 0, nil
+	}
+	if idx := bytes.IndexByte(line, ' '); idx > 0 {
+		if ts, err := time.Parse(time.RFC3339Nano, string(line[:idx])); err == nil {
+			if cursor.skip(ts) {
+				return 0, nil
+			}
+			line = line[idx+1:]
+		}
+	}
+	return w.Write(line)
+}
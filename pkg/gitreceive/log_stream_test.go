@@ -0,0 +1,119 @@
+package gitreceive
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arschles/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestCopyPodLogs(t *testing.T) {
+	cursor := new(podLogCursor)
+	out := new(bytes.Buffer)
+	logs := "2016-01-02T15:04:05.000000001Z -----> Fetching buildpack\n" +
+		"not timestamped\n" +
+		"2016-01-02T15:04:05.000000001Z -----> Fetched\n" +
+		"2016-01-02T15:04:06.000000001Z -----> Compiling"
+	n, partial, err := copyPodLogs(out, strings.NewReader(logs), cursor)
+	assert.NoErr(t, err)
+	// lines sharing a timestamp in a stream are all written
+	expected := "-----> Fetching buildpack\nnot timestamped\n-----> Fetched\n"
+	assert.Equal(t, out.String(), expected, "output")
+	assert.Equal(t, n, int64(len(expected)), "bytes written")
+	// the last line may have been cut off, so it's neither written nor seen
+	assert.Equal(t, string(partial), "2016-01-02T15:04:06.000000001Z -----> Compiling", "partial line")
+	assert.Equal(t, cursor.lastSeen, time.Date(2016, 1, 2, 15, 4, 5, 1, time.UTC), "last seen")
+	assert.Equal(t, cursor.atLastSeen, 2, "lines written at last seen")
+
+	// a reopened stream replays the lines written up to lastSeen, which must be skipped
+	out.Reset()
+	cursor.reopen()
+	logs = "2016-01-02T15:04:04Z -----> Detecting\n" +
+		"2016-01-02T15:04:05.000000001Z -----> Fetching buildpack\n" +
+		"2016-01-02T15:04:05.000000001Z -----> Fetched\n" +
+		"2016-01-02T15:04:05.000000001Z -----> Fetched again\n" +
+		"2016-01-02T15:04:06.000000001Z -----> Compiling\n" +
+		"2016-01-02T15:04:07Z -----> Launching\n"
+	_, _, err = copyPodLogs(out, strings.NewReader(logs), cursor)
+	assert.NoErr(t, err)
+	assert.Equal(t, out.String(), "-----> Fetched again\n-----> Compiling\n-----> Launching\n", "output")
+}
+
+func TestFollowPodLogsReconnects(t *testing.T) {
+	streams := []string{
+		"2016-01-02T15:04:05Z first\n2016-01-02T15:04:06Z second\n2016-01-02T15:04:07Z thi",
+		"2016-01-02T15:04:06Z second\n2016-01-02T15:04:07Z third\n2016-01-02T15:04:08Z last",
+	}
+	var opts []*api.PodLogOptions
+	stream := func(o *api.PodLogOptions) (io.ReadCloser, error) {
+		opts = append(opts, o)
+		return ioutil.NopCloser(strings.NewReader(streams[len(opts)-1])), nil
+	}
+	terminated := func() bool {
+		return len(opts) == len(streams)
+	}
+	out := new(bytes.Buffer)
+	_, err := followPodLogs(out, stream, terminated, 3, time.Millisecond)
+	assert.NoErr(t, err)
+	assert.Equal(t, out.String(), "first\nsecond\nthird\nlast", "output")
+	assert.Equal(t, len(opts), 2, "number of streams opened")
+	assert.True(t, opts[0].SinceTime == nil, "first stream had a SinceTime")
+	assert.Equal(t, opts[1].SinceTime.Time, time.Date(2016, 1, 2, 15, 4, 6, 0, time.UTC), "since time")
+}
+
+func TestFollowPodLogsMaxReconnects(t *testing.T) {
+	calls := 0
+	stream := func(o *api.PodLogOptions) (io.ReadCloser, error) {
+		calls++
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	terminated := func() bool { return false }
+	_, err := followPodLogs(new(bytes.Buffer), stream, terminated, 2, time.Millisecond)
+	assert.True(t, err != nil, "no error received when there should have been")
+	assert.Equal(t, calls, 3, "number of streams opened")
+}
+
+func TestWaitPodTerminated(t *testing.T) {
+	checks := 0
+	terminated := func() bool {
+		checks++
+		return checks == 3
+	}
+	start := time.Now()
+	assert.True(t, waitPodTerminated(terminated, time.Minute), "pod not seen terminated")
+	// the pod status is polled rather than checked once the full timeout elapsed
+	assert.True(t, time.Since(start) < 10*podTerminationPollInterval, "waited for the full timeout")
+
+	assert.False(t, waitPodTerminated(func() bool { return false }, time.Millisecond), "pod seen terminated")
+}
+
+func TestFollowPodLogsStreamErr(t *testing.T) {
+	calls := 0
+	stream := func(o *api.PodLogOptions) (io.ReadCloser, error) {
+		calls++
+		return nil, errors.New("test error")
+	}
+	_, err := followPodLogs(new(bytes.Buffer), stream, func() bool { return true }, 2, time.Millisecond)
+	assert.True(t, err != nil, "no error received when there should have been")
+	assert.Equal(t, calls, 3, "number of attempts to open a stream")
+
+	// a failure to reopen the stream is retried
+	calls = 0
+	stream = func(o *api.PodLogOptions) (io.ReadCloser, error) {
+		calls++
+		if calls == 2 {
+			return nil, errors.New("test error")
+		}
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	terminated := func() bool { return calls == 3 }
+	_, err = followPodLogs(new(bytes.Buffer), stream, terminated, 2, time.Millisecond)
+	assert.NoErr(t, err)
+	assert.Equal(t, calls, 3, "number of attempts to open a stream")
+}
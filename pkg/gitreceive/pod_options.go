@@ -0,0 +1,322 @@
+package gitreceive
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+)
+
+// App config keys that override the cluster wide builder pod options for a single app. Any
+// collaborator of an app can set its config, and builder pods run the code of the app, so the
+// tolerations, node affinity and service account an app sets must be allowed by the operator:
+// tolerations are limited to the taint keys of BUILDER_POD_TOLERATIONS_ALLOWED and add to the
+// cluster wide ones, node affinities are limited to the label keys of
+// BUILDER_POD_AFFINITY_KEYS_ALLOWED and can only narrow the cluster wide one, and service accounts
+// to the ones of BUILDER_POD_SERVICE_ACCOUNTS_ALLOWED.
+const (
+	appCPURequestKey     = "DEIS_BUILDER_CPU_REQUEST"
+	appCPULimitKey       = "DEIS_BUILDER_CPU_LIMIT"
+	appMemoryRequestKey  = "DEIS_BUILDER_MEMORY_REQUEST"
+	appMemoryLimitKey    = "DEIS_BUILDER_MEMORY_LIMIT"
+	appTolerationsKey    = "DEIS_BUILDER_TOLERATIONS"
+	appNodeAffinityKey   = "DEIS_BUILDER_NODE_AFFINITY"
+	appServiceAccountKey = "DEIS_BUILDER_SERVICE_ACCOUNT"
+	// appPriorityClassKey is rejected rather than ignored: the Kubernetes API version the builder
+	// uses has no priority classes.
+	appPriorityClassKey = "DEIS_BUILDER_PRIORITY_CLASS"
+
+	tolerationsAnnotationKey = "scheduler.alpha.kubernetes.io/tolerations"
+	affinityAnnotationKey    = "scheduler.alpha.kubernetes.io/affinity"
+)
+
+var (
+	serviceAccountRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+	validTolerationOperators = map[string]struct{}{"": {}, "Equal": {}, "Exists": {}}
+	validTolerationEffects   = map[string]struct{}{"": {}, "NoSchedule": {}, "PreferNoSchedule": {}}
+)
+
+// toleration is a single entry of the tolerations annotation understood by the scheduler.
+type toleration struct {
+	Key      string `json:"key,omitempty"`
+	Operator string `json:"operator,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Effect   string `json:"effect,omitempty"`
+}
+
+// builderPodOptions holds the resources and scheduling constraints of builder pods. The raw values
+// come from the builder config, optionally overridden by the app config.
+type builderPodOptions struct {
+	cpuRequest     string
+	cpuLimit       string
+	memoryRequest  string
+	memoryLimit    string
+	tolerations    string
+	nodeAffinity   string
+	serviceAccount string
+	// appTolerations and appNodeAffinity come from the app config, and are combined with the
+	// cluster wide tolerations and nodeAffinity
+	appTolerations  string
+	appNodeAffinity string
+}
+
+// newBuilderPodOptions returns the builder pod options of the app with the given config values,
+// falling back to the cluster wide defaults in conf. It returns an error if any of the options is
+// invalid or not allowed by conf, so that the build fails before a pod is created.
+func newBuilderPodOptions(conf *Config, appValues map[string]interface{}) (*builderPodOptions, error) {
+	opts := &builderPodOptions{
+		cpuRequest:      appValueOrDefault(appValues, appCPURequestKey, conf.BuilderPodCPURequest),
+		cpuLimit:        appValueOrDefault(appValues, appCPULimitKey, conf.BuilderPodCPULimit),
+		memoryRequest:   appValueOrDefault(appValues, appMemoryRequestKey, conf.BuilderPodMemoryRequest),
+		memoryLimit:     appValueOrDefault(appValues, appMemoryLimitKey, conf.BuilderPodMemoryLimit),
+		tolerations:     conf.BuilderPodTolerations,
+		nodeAffinity:    conf.BuilderPodNodeAffinity,
+		serviceAccount:  appValueOrDefault(appValues, appServiceAccountKey, conf.BuilderPodServiceAccount),
+		appTolerations:  appValueOrDefault(appValues, appTolerationsKey, ""),
+		appNodeAffinity: appValueOrDefault(appValues, appNodeAffinityKey, ""),
+	}
+	if _, ok := appValues[appPriorityClassKey]; ok {
+		return nil, fmt.Errorf("%s can't be set, the Kubernetes API version of the builder has no priority classes", appPriorityClassKey)
+	}
+	if _, err := opts.resources(); err != nil {
+		return nil, err
+	}
+	if _, err := opts.annotations(); err != nil {
+		return nil, err
+	}
+	if opts.serviceAccount != "" && !serviceAccountRegex.MatchString(opts.serviceAccount) {
+		return nil, fmt.Errorf("invalid builder pod service account %q", opts.serviceAccount)
+	}
+	if opts.serviceAccount != conf.BuilderPodServiceAccount && !optionAllowed(conf.BuilderPodAccountsAllowed, opts.serviceAccount) {
+		return nil, fmt.Errorf("the %s builder pod service account is not allowed on this cluster, unset %s", opts.serviceAccount, appServiceAccountKey)
+	}
+	// the app values were parsed by annotations already
+	appTolerations, _ := parseTolerations(opts.appTolerations)
+	for _, t := range appTolerations {
+		if t.Key == "" || !optionAllowed(conf.BuilderPodTolerationsAllowed, t.Key) {
+			return nil, fmt.Errorf("tolerating the %q taint key is not allowed on this cluster, fix %s", t.Key, appTolerationsKey)
+		}
+	}
+	appNodeAffinity, _ := parseNodeAffinity(opts.appNodeAffinity)
+	for _, key := range nodeAffinityKeys(appNodeAffinity) {
+		if !optionAllowed(conf.BuilderPodAffinityKeysAllowed, key) {
+			return nil, fmt.Errorf("node affinities on the %q label key are not allowed on this cluster, fix %s", key, appNodeAffinityKey)
+		}
+	}
+	return opts, nil
+}
+
+// optionAllowed returns true if value is in the comma separated list of allowed values. An empty
+// value is never allowed.
+func optionAllowed(allowed, value string) bool {
+	for _, a := range strings.Split(allowed, ",") {
+		if a = strings.TrimSpace(a); a != "" && a == value {
+			return true
+		}
+	}
+	return false
+}
+
+func appValueOrDefault(appValues map[string]interface{}, key, def string) string {
+	if val, ok := appValues[key]; ok {
+		return fmt.Sprintf("%v", val)
+	}
+	return def
+}
+
+// resources parses the resource requests and limits of the builder pod container.
+func (o builderPodOptions) resources() (api.ResourceRequirements, error) {
+	reqs := api.ResourceRequirements{
+		Requests: api.ResourceList{},
+		Limits:   api.ResourceList{},
+	}
+	quantities := []struct {
+		name  string
+		value string
+		list  api.ResourceList
+		res   api.ResourceName
+	}{
+		{"cpu request", o.cpuRequest, reqs.Requests, api.ResourceCPU},
+		{"cpu limit", o.cpuLimit, reqs.Limits, api.ResourceCPU},
+		{"memory request", o.memoryRequest, reqs.Requests, api.ResourceMemory},
+		{"memory limit", o.memoryLimit, reqs.Limits, api.ResourceMemory},
+	}
+	for _, q := range quantities {
+		if q.value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(q.value)
+		if err != nil {
+			return reqs, fmt.Errorf("invalid builder pod %s %q (%s)", q.name, q.value, err)
+		}
+		q.list[q.res] = *quantity
+	}
+	for _, res := range []api.ResourceName{api.ResourceCPU, api.ResourceMemory} {
+		request, hasRequest := reqs.Requests[res]
+		limit, hasLimit := reqs.Limits[res]
+		if hasRequest && hasLimit && request.Cmp(limit) > 0 {
+			return reqs, fmt.Errorf("builder pod %s request %s is greater than its limit %s", res, request.String(), limit.String())
+		}
+	}
+	return reqs, nil
+}
+
+// annotations returns the scheduler annotations holding the tolerations and the node affinity of
+// the builder pod, combining the cluster wide and the app ones.
+func (o builderPodOptions) annotations() (map[string]string, error) {
+	annotations := make(map[string]string)
+	tolerations, err := parseTolerations(o.tolerations)
+	if err != nil {
+		return nil, err
+	}
+	appTolerations, err := parseTolerations(o.appTolerations)
+	if err != nil {
+		return nil, err
+	}
+	if tolerations = append(tolerations, appTolerations...); len(tolerations) > 0 {
+		tolerationsJSON, err := json.Marshal(tolerations)
+		if err != nil {
+			return nil, err
+		}
+		annotations[tolerationsAnnotationKey] = string(tolerationsJSON)
+	}
+	nodeAffinity, err := parseNodeAffinity(o.nodeAffinity)
+	if err != nil {
+		return nil, err
+	}
+	appNodeAffinity, err := parseNodeAffinity(o.appNodeAffinity)
+	if err != nil {
+		return nil, err
+	}
+	if nodeAffinity = mergeNodeAffinities(nodeAffinity, appNodeAffinity); nodeAffinity != nil {
+		affinityJSON, err := json.Marshal(api.Affinity{NodeAffinity: nodeAffinity})
+		if err != nil {
+			return nil, err
+		}
+		annotations[affinityAnnotationKey] = string(affinityJSON)
+	}
+	return annotations, nil
+}
+
+// parseTolerations parses and validates the JSON list of tolerations in raw, which may be empty.
+func parseTolerations(raw string) ([]toleration, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var tolerations []toleration
+	if err := json.Unmarshal([]byte(raw), &tolerations); err != nil {
+		return nil, fmt.Errorf("invalid builder pod tolerations %q (%s)", raw, err)
+	}
+	for _, t := range tolerations {
+		if _, ok := validTolerationOperators[t.Operator]; !ok {
+			return nil, fmt.Errorf("invalid builder pod toleration operator %q", t.Operator)
+		}
+		if _, ok := validTolerationEffects[t.Effect]; !ok {
+			return nil, fmt.Errorf("invalid builder pod toleration effect %q", t.Effect)
+		}
+	}
+	return tolerations, nil
+}
+
+// parseNodeAffinity parses the JSON node affinity in raw. It returns nil if raw is empty.
+func parseNodeAffinity(raw string) (*api.NodeAffinity, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	nodeAffinity := new(api.NodeAffinity)
+	if err := json.Unmarshal([]byte(raw), nodeAffinity); err != nil {
+		return nil, fmt.Errorf("invalid builder pod node affinity %q (%s)", raw, err)
+	}
+	return nodeAffinity, nil
+}
+
+// nodeAffinityKeys returns the node label keys nodeAffinity matches on.
+func nodeAffinityKeys(nodeAffinity *api.NodeAffinity) []string {
+	if nodeAffinity == nil {
+		return nil
+	}
+	var terms []api.NodeSelectorTerm
+	if nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		terms = append(terms, nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms...)
+	}
+	for _, preferred := range nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		terms = append(terms, preferred.Preference)
+	}
+	var keys []string
+	for _, term := range terms {
+		for _, expr := range term.MatchExpressions {
+			keys = append(keys, expr.Key)
+		}
+	}
+	return keys
+}
+
+// mergeNodeAffinities returns a node affinity requiring nodes to match both a and b, either of
+// which may be nil, and preferring the nodes either of them prefers. Since node selector terms are
+// ORed and the expressions of a term are ANDed, the required terms are the expressions of each term
+// of a joined with the ones of each term of b.
+func mergeNodeAffinities(a, b *api.NodeAffinity) *api.NodeAffinity {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	merged := new(api.NodeAffinity)
+	preferred := append([]api.PreferredSchedulingTerm{}, a.PreferredDuringSchedulingIgnoredDuringExecution...)
+	if preferred = append(preferred, b.PreferredDuringSchedulingIgnoredDuringExecution...); len(preferred) > 0 {
+		merged.PreferredDuringSchedulingIgnoredDuringExecution = preferred
+	}
+	aRequired, bRequired := a.RequiredDuringSchedulingIgnoredDuringExecution, b.RequiredDuringSchedulingIgnoredDuringExecution
+	switch {
+	case aRequired == nil:
+		merged.RequiredDuringSchedulingIgnoredDuringExecution = bRequired
+	case bRequired == nil:
+		merged.RequiredDuringSchedulingIgnoredDuringExecution = aRequired
+	default:
+		required := new(api.NodeSelector)
+		for _, aTerm := range aRequired.NodeSelectorTerms {
+			for _, bTerm := range bRequired.NodeSelectorTerms {
+				exprs := append(append([]api.NodeSelectorRequirement{}, aTerm.MatchExpressions...), bTerm.MatchExpressions...)
+				required.NodeSelectorTerms = append(required.NodeSelectorTerms, api.NodeSelectorTerm{MatchExpressions: exprs})
+			}
+		}
+		merged.RequiredDuringSchedulingIgnoredDuringExecution = required
+	}
+	return merged
+}
+
+// apply sets the options on pod. The options must have been validated by newBuilderPodOptions.
+func (o builderPodOptions) apply(pod *api.Pod) {
+	if reqs, err := o.resources(); err == nil && len(pod.Spec.Containers) > 0 {
+		// only override the resources that are set, keeping the rest from the pod template
+		resources := &pod.Spec.Containers[0].Resources
+		if resources.Requests == nil {
+			resources.Requests = api.ResourceList{}
+		}
+		if resources.Limits == nil {
+			resources.Limits = api.ResourceList{}
+		}
+		for res, quantity := range reqs.Requests {
+			resources.Requests[res] = quantity
+		}
+		for res, quantity := range reqs.Limits {
+			resources.Limits[res] = quantity
+		}
+	}
+	if annotations, err := o.annotations(); err == nil && len(annotations) > 0 {
+		if pod.ObjectMeta.Annotations == nil {
+			pod.ObjectMeta.Annotations = make(map[string]string)
+		}
+		for k, v := range annotations {
+			pod.ObjectMeta.Annotations[k] = v
+		}
+	}
+	if o.serviceAccount != "" {
+		pod.Spec.ServiceAccountName = o.serviceAccount
+	}
+}
@@ -0,0 +1,124 @@
+package gitreceive
+
+import (
+	"testing"
+
+	"github.com/arschles/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestNewBuilderPodOptionsDefaults(t *testing.T) {
+	conf := &Config{
+		BuilderPodCPURequest:     "100m",
+		BuilderPodMemoryLimit:    "1Gi",
+		BuilderPodServiceAccount: "builder",
+	}
+	appValues := map[string]interface{}{appMemoryLimitKey: "2Gi"}
+	opts, err := newBuilderPodOptions(conf, appValues)
+	assert.NoErr(t, err)
+
+	pod := &api.Pod{Spec: api.PodSpec{Containers: []api.Container{{}}}}
+	opts.apply(pod)
+	resources := pod.Spec.Containers[0].Resources
+	cpuRequest := resources.Requests[api.ResourceCPU]
+	memoryLimit := resources.Limits[api.ResourceMemory]
+	assert.Equal(t, cpuRequest.String(), "100m", "cpu request")
+	assert.Equal(t, memoryLimit.String(), "2Gi", "memory limit")
+	_, ok := resources.Limits[api.ResourceCPU]
+	assert.False(t, ok, "cpu limit was set")
+	assert.Equal(t, pod.Spec.ServiceAccountName, "builder", "service account")
+	assert.Equal(t, len(pod.ObjectMeta.Annotations), 0, "number of annotations")
+}
+
+func TestNewBuilderPodOptionsScheduling(t *testing.T) {
+	conf := &Config{
+		BuilderPodTolerations:  `[{"key":"dedicated","operator":"Equal","value":"builds","effect":"NoSchedule"}]`,
+		BuilderPodNodeAffinity: `{"requiredDuringSchedulingIgnoredDuringExecution":{"nodeSelectorTerms":[{"matchExpressions":[{"key":"disk","operator":"In","values":["ssd"]}]}]}}`,
+	}
+	opts, err := newBuilderPodOptions(conf, nil)
+	assert.NoErr(t, err)
+
+	pod := &api.Pod{Spec: api.PodSpec{Containers: []api.Container{{}}}}
+	opts.apply(pod)
+	assert.Equal(t, pod.ObjectMeta.Annotations[tolerationsAnnotationKey], `[{"key":"dedicated","operator":"Equal","value":"builds","effect":"NoSchedule"}]`, "tolerations")
+	assert.Equal(t, pod.ObjectMeta.Annotations[affinityAnnotationKey], `{"nodeAffinity":{"requiredDuringSchedulingIgnoredDuringExecution":{"nodeSelectorTerms":[{"matchExpressions":[{"key":"disk","operator":"In","values":["ssd"]}]}]}}}`, "affinity")
+}
+
+func TestNewBuilderPodOptionsApp(t *testing.T) {
+	conf := &Config{
+		BuilderPodTolerations:         `[{"key":"dedicated","operator":"Equal","value":"builds","effect":"NoSchedule"}]`,
+		BuilderPodNodeAffinity:        `{"requiredDuringSchedulingIgnoredDuringExecution":{"nodeSelectorTerms":[{"matchExpressions":[{"key":"pool","operator":"In","values":["builds"]}]}]}}`,
+		BuilderPodServiceAccount:      "builder",
+		BuilderPodTolerationsAllowed:  "gpu",
+		BuilderPodAffinityKeysAllowed: "disk, zone",
+		BuilderPodAccountsAllowed:     "builder-gcr,builder-ecr",
+	}
+	appValues := map[string]interface{}{
+		appTolerationsKey:    `[{"key":"gpu","operator":"Exists"}]`,
+		appNodeAffinityKey:   `{"requiredDuringSchedulingIgnoredDuringExecution":{"nodeSelectorTerms":[{"matchExpressions":[{"key":"disk","operator":"In","values":["ssd"]}]}]}}`,
+		appServiceAccountKey: "builder-ecr",
+	}
+	opts, err := newBuilderPodOptions(conf, appValues)
+	assert.NoErr(t, err)
+
+	pod := &api.Pod{Spec: api.PodSpec{Containers: []api.Container{{}}}}
+	opts.apply(pod)
+	assert.Equal(t, pod.Spec.ServiceAccountName, "builder-ecr", "service account")
+	// app tolerations add to the cluster wide ones, and app node affinities narrow the cluster wide one
+	assert.Equal(t, pod.ObjectMeta.Annotations[tolerationsAnnotationKey], `[{"key":"dedicated","operator":"Equal","value":"builds","effect":"NoSchedule"},{"key":"gpu","operator":"Exists"}]`, "tolerations")
+	assert.Equal(t, pod.ObjectMeta.Annotations[affinityAnnotationKey], `{"nodeAffinity":{"requiredDuringSchedulingIgnoredDuringExecution":{"nodeSelectorTerms":[{"matchExpressions":[{"key":"pool","operator":"In","values":["builds"]},{"key":"disk","operator":"In","values":["ssd"]}]}]}}}`, "affinity")
+
+	notAllowed := []map[string]interface{}{
+		{appTolerationsKey: `[{"key":"dedicated","operator":"Exists"}]`},
+		{appTolerationsKey: `[{"operator":"Exists"}]`},
+		{appNodeAffinityKey: `{"preferredDuringSchedulingIgnoredDuringExecution":[{"weight":1,"preference":{"matchExpressions":[{"key":"pool","operator":"In","values":["system"]}]}}]}`},
+		{appServiceAccountKey: "deis-controller"},
+		{appServiceAccountKey: ""},
+		{appPriorityClassKey: "high"},
+	}
+	for _, appValues := range notAllowed {
+		if _, err := newBuilderPodOptions(conf, appValues); err == nil {
+			t.Errorf("expected builder pod options %v not to be allowed", appValues)
+		}
+	}
+}
+
+func TestMergeNodeAffinities(t *testing.T) {
+	term := func(key string) api.NodeSelectorTerm {
+		return api.NodeSelectorTerm{MatchExpressions: []api.NodeSelectorRequirement{{Key: key, Operator: api.NodeSelectorOpExists}}}
+	}
+	a := &api.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &api.NodeSelector{NodeSelectorTerms: []api.NodeSelectorTerm{term("a1"), term("a2")}}}
+	b := &api.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &api.NodeSelector{NodeSelectorTerms: []api.NodeSelectorTerm{term("b")}}}
+	assert.True(t, mergeNodeAffinities(a, nil) == a, "merge with nil")
+	merged := mergeNodeAffinities(a, b).RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	assert.Equal(t, len(merged), 2, "number of terms")
+	assert.Equal(t, merged[0].MatchExpressions, append(term("a1").MatchExpressions, term("b").MatchExpressions...), "first term")
+	assert.Equal(t, merged[1].MatchExpressions, append(term("a2").MatchExpressions, term("b").MatchExpressions...), "second term")
+}
+
+func TestNewBuilderPodOptionsInvalid(t *testing.T) {
+	appCases := []map[string]interface{}{
+		{appCPURequestKey: "lots"},
+		{appMemoryLimitKey: "1Gb"},
+		{appMemoryRequestKey: "2Gi", appMemoryLimitKey: "1Gi"},
+		{appTolerationsKey: `{"key":"dedicated"}`},
+		{appNodeAffinityKey: "disk=ssd"},
+	}
+	for _, appValues := range appCases {
+		if _, err := newBuilderPodOptions(&Config{}, appValues); err == nil {
+			t.Errorf("expected builder pod options %v to be invalid", appValues)
+		}
+	}
+	confCases := []*Config{
+		{BuilderPodTolerations: `{"key":"dedicated"}`},
+		{BuilderPodTolerations: `[{"key":"dedicated","operator":"Matches"}]`},
+		{BuilderPodTolerations: `[{"key":"dedicated","effect":"NoExecute"}]`},
+		{BuilderPodNodeAffinity: "disk=ssd"},
+		{BuilderPodServiceAccount: "Builder_Account"},
+	}
+	for _, conf := range confCases {
+		if _, err := newBuilderPodOptions(conf, nil); err == nil {
+			t.Errorf("expected builder pod options %+v to be invalid", *conf)
+		}
+	}
+}
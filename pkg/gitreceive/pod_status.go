@@ -0,0 +1,137 @@
+package gitreceive
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/deis/pkg/log"
+	"k8s.io/kubernetes/pkg/api"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/fields"
+)
+
+// podEventsCheckInterval is the minimum interval between two listings of the events of a builder
+// pod, so that waiting for the pod doesn't flood the API server.
+const podEventsCheckInterval = 2 * time.Second
+
+// failedSchedulingReason is the reason of the events of a pod that no node can run yet.
+const failedSchedulingReason = "FailedScheduling"
+
+var (
+	// transientWaitingReasons are the container waiting reasons worth showing to the user while a
+	// builder pod starts, but which kubernetes may still recover from.
+	transientWaitingReasons = map[string]struct{}{
+		"ErrImagePull":        {},
+		"ImagePullBackOff":    {},
+		"RegistryUnavailable": {},
+	}
+	// terminalWaitingReasons are the container waiting reasons after which a builder pod will
+	// never start.
+	terminalWaitingReasons = map[string]struct{}{
+		"InvalidImageName":           {},
+		"ErrImageNeverPull":          {},
+		"CreateContainerConfigError": {},
+	}
+	// terminalEventReasons are the reasons of the events after which a builder pod will never
+	// start. FailedScheduling isn't one of them: a cluster autoscaler may be adding a node for the
+	// pod, so it only fails the pod once it persists past the scheduling timeout.
+	terminalEventReasons = map[string]struct{}{
+		"InspectFailed":     {},
+		"ErrImageNeverPull": {},
+	}
+)
+
+// podEventLister lists the kubernetes events of a builder pod.
+type podEventLister func() ([]api.Event, error)
+
+// kubePodEventLister returns a podEventLister that lists the events of the given pod from the
+// kubernetes API.
+func kubePodEventLister(kubeClient *client.Client, ns, podName string) podEventLister {
+	return func() ([]api.Event, error) {
+		events, err := kubeClient.Events(ns).List(api.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("involvedObject.name", podName),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return events.Items, nil
+	}
+}
+
+// podStatusReporter shows the user why a builder pod isn't running yet, based on the waiting
+// state of its containers and on its events. Every message is shown once.
+type podStatusReporter struct {
+	events            podEventLister
+	schedulingTimeout time.Duration
+	seen              map[string]struct{}
+	lastEventsCheck   time.Time
+	// unscheduledSince is when a FailedScheduling event was first listed
+	unscheduledSince time.Time
+}
+
+func newPodStatusReporter(events podEventLister, schedulingTimeout time.Duration) *podStatusReporter {
+	return &podStatusReporter{events: events, schedulingTimeout: schedulingTimeout, seen: make(map[string]struct{})}
+}
+
+// report shows any new reason for pod to be pending. It returns an error if one of them means
+// that the pod will never start.
+func (r *podStatusReporter) report(pod *api.Pod) error {
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		waiting := containerStatus.State.Waiting
+		if waiting == nil {
+			continue
+		}
+		msg := fmt.Sprintf("%s: %s", waiting.Reason, waiting.Message)
+		if _, ok := terminalWaitingReasons[waiting.Reason]; ok {
+			return fmt.Errorf("builder pod can't start (%s)", msg)
+		}
+		if _, ok := transientWaitingReasons[waiting.Reason]; ok {
+			r.show(msg)
+		}
+	}
+
+	if r.events == nil || time.Since(r.lastEventsCheck) < podEventsCheckInterval {
+		return nil
+	}
+	r.lastEventsCheck = time.Now()
+	events, err := r.events()
+	if err != nil {
+		log.Debug("unable to list events for pod %s (%s)", pod.Name, err)
+		return nil
+	}
+	for _, event := range events {
+		if event.Type != api.EventTypeWarning {
+			continue
+		}
+		msg := fmt.Sprintf("%s: %s", event.Reason, event.Message)
+		if _, ok := terminalEventReasons[event.Reason]; ok {
+			return fmt.Errorf("builder pod can't start (%s)", msg)
+		}
+		if event.Reason == failedSchedulingReason {
+			// events of a pod that was scheduled since are outdated
+			if pod.Spec.NodeName != "" {
+				continue
+			}
+			if r.unscheduledSince.IsZero() {
+				r.unscheduledSince = time.Now()
+			}
+			since := event.FirstTimestamp.Time
+			if since.IsZero() {
+				since = r.unscheduledSince
+			}
+			if time.Since(since) >= r.schedulingTimeout {
+				return fmt.Errorf("builder pod can't start, it wasn't scheduled for %s (%s)", r.schedulingTimeout, msg)
+			}
+		}
+		r.show(msg)
+	}
+	return nil
+}
+
+func (r *podStatusReporter) show(msg string) {
+	if _, ok := r.seen[msg]; ok {
+		return
+	}
+	r.seen[msg] = struct{}{}
+	log.Info("Waiting for the builder pod: %s", msg)
+}
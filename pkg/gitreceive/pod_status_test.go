@@ -0,0 +1,88 @@
+package gitreceive
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/arschles/assert"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+func podWaitingFor(reason string) *api.Pod {
+	return &api.Pod{
+		Status: api.PodStatus{
+			Phase: api.PodPending,
+			ContainerStatuses: []api.ContainerStatus{
+				{State: api.ContainerState{Waiting: &api.ContainerStateWaiting{Reason: reason, Message: "message"}}},
+			},
+		},
+	}
+}
+
+func TestPodStatusReporterWaiting(t *testing.T) {
+	reporter := newPodStatusReporter(nil, time.Minute)
+	for _, reason := range []string{"ContainerCreating", "ErrImagePull", "ImagePullBackOff"} {
+		assert.NoErr(t, reporter.report(podWaitingFor(reason)))
+	}
+	_, ok := reporter.seen["ImagePullBackOff: message"]
+	assert.True(t, ok, "ImagePullBackOff was not shown")
+	_, ok = reporter.seen["ContainerCreating: message"]
+	assert.False(t, ok, "ContainerCreating was shown")
+
+	err := reporter.report(podWaitingFor("InvalidImageName"))
+	assert.Err(t, err, errors.New("builder pod can't start (InvalidImageName: message)"))
+}
+
+func TestPodStatusReporterEvents(t *testing.T) {
+	events := []api.Event{
+		{Type: api.EventTypeNormal, Reason: "Pulling", Message: "pulling image"},
+		{Type: api.EventTypeWarning, Reason: "FailedSync", Message: "error syncing pod"},
+	}
+	reporter := newPodStatusReporter(func() ([]api.Event, error) {
+		return events, nil
+	}, time.Minute)
+	assert.NoErr(t, reporter.report(&api.Pod{}))
+	_, ok := reporter.seen["FailedSync: error syncing pod"]
+	assert.True(t, ok, "warning event was not shown")
+	assert.Equal(t, len(reporter.seen), 1, "number of messages shown")
+
+	events = append(events, api.Event{Type: api.EventTypeWarning, Reason: "InspectFailed", Message: "invalid image"})
+	reporter.lastEventsCheck = reporter.lastEventsCheck.Add(-podEventsCheckInterval)
+	err := reporter.report(&api.Pod{})
+	assert.Err(t, err, errors.New("builder pod can't start (InspectFailed: invalid image)"))
+}
+
+func TestPodStatusReporterFailedScheduling(t *testing.T) {
+	event := api.Event{
+		Type:           api.EventTypeWarning,
+		Reason:         "FailedScheduling",
+		Message:        "no nodes available",
+		FirstTimestamp: unversioned.NewTime(time.Now().Add(-30 * time.Second)),
+	}
+	reporter := newPodStatusReporter(func() ([]api.Event, error) {
+		return []api.Event{event}, nil
+	}, time.Minute)
+
+	// a cluster autoscaler may still add a node for the pod
+	assert.NoErr(t, reporter.report(&api.Pod{}))
+	_, ok := reporter.seen["FailedScheduling: no nodes available"]
+	assert.True(t, ok, "FailedScheduling was not shown")
+
+	event.FirstTimestamp = unversioned.NewTime(time.Now().Add(-2 * time.Minute))
+	reporter.lastEventsCheck = reporter.lastEventsCheck.Add(-podEventsCheckInterval)
+	err := reporter.report(&api.Pod{})
+	assert.Err(t, err, errors.New("builder pod can't start, it wasn't scheduled for 1m0s (FailedScheduling: no nodes available)"))
+
+	// the event is outdated once the pod is scheduled
+	reporter.lastEventsCheck = reporter.lastEventsCheck.Add(-podEventsCheckInterval)
+	assert.NoErr(t, reporter.report(&api.Pod{Spec: api.PodSpec{NodeName: "node1"}}))
+}
+
+func TestPodStatusReporterEventsErr(t *testing.T) {
+	reporter := newPodStatusReporter(func() ([]api.Event, error) {
+		return nil, errors.New("test error")
+	}, time.Minute)
+	assert.NoErr(t, reporter.report(&api.Pod{}))
+}
@@ -0,0 +1,51 @@
+package gitreceive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+// loadPodTemplate reads the builder pod template at path. The template is optional, so it returns
+// nil and no error if there's no file at path.
+//
+// The builder merges every pod it creates into a copy of the template: the first container of
+// the template becomes the builder container, and any other container, volume, label or
+// annotation in the template is kept as is. See buildPod for details.
+func loadPodTemplate(path string) (*api.Pod, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading builder pod template %s (%s)", path, err)
+	}
+	template := new(api.Pod)
+	if err := yaml.Unmarshal(data, template); err != nil {
+		return nil, fmt.Errorf("builder pod template %s is malformed (%s)", path, err)
+	}
+	return template, nil
+}
+
+// copyPodTemplate returns a deep copy of template, or an empty pod if template is nil.
+func copyPodTemplate(template *api.Pod) (api.Pod, error) {
+	pod := api.Pod{}
+	if template == nil {
+		return pod, nil
+	}
+	// the template was read from JSON compatible YAML, so a JSON round trip copies all of it
+	data, err := json.Marshal(template)
+	if err != nil {
+		return pod, fmt.Errorf("copying builder pod template (%s)", err)
+	}
+	if err := json.Unmarshal(data, &pod); err != nil {
+		return api.Pod{}, fmt.Errorf("copying builder pod template (%s)", err)
+	}
+	return pod, nil
+}
@@ -0,0 +1,100 @@
+package gitreceive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arschles/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const testPodTemplate = `
+metadata:
+  annotations:
+    sidecar.istio.io/inject: "false"
+  labels:
+    team: platform
+spec:
+  securityContext:
+    runAsNonRoot: true
+  nodeSelector:
+    pool: builds
+  containers:
+  - name: builder
+    env:
+    - name: HTTP_PROXY
+      value: http://proxy:3128
+  - name: sidecar
+    image: sidecar:latest
+  volumes:
+  - name: extra
+    emptyDir: {}
+`
+
+func TestLoadPodTemplateMissing(t *testing.T) {
+	template, err := loadPodTemplate("/this/file/does/not/exist.yaml")
+	assert.NoErr(t, err)
+	assert.True(t, template == nil, "template was loaded from a missing file")
+
+	template, err = loadPodTemplate("")
+	assert.NoErr(t, err)
+	assert.True(t, template == nil, "template was loaded from an empty path")
+}
+
+func TestLoadPodTemplateMalformed(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tmpdir")
+	if err != nil {
+		t.Fatalf("error creating temp directory (%s)", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "pod.yaml")
+	if err := ioutil.WriteFile(path, []byte("spec: [this is not a pod spec"), 0644); err != nil {
+		t.Fatalf("error creating %s (%s)", path, err)
+	}
+	_, err = loadPodTemplate(path)
+	assert.True(t, err != nil, "no error received when there should have been")
+}
+
+func TestBuildPodWithTemplate(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tmpdir")
+	if err != nil {
+		t.Fatalf("error creating temp directory (%s)", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "pod.yaml")
+	if err := ioutil.WriteFile(path, []byte(testPodTemplate), 0644); err != nil {
+		t.Fatalf("error creating %s (%s)", path, err)
+	}
+	template, err := loadPodTemplate(path)
+	assert.NoErr(t, err)
+
+	nodeSelector := map[string]string{"disk": "ssd"}
+	pod, err := slugbuilderPod(false, "test", "default", "test-build-env", "tar", "put-url", "", "deadbeef", "", "", "slugbuilder", api.PullAlways, nodeSelector, template)
+	assert.NoErr(t, err)
+
+	assert.Equal(t, pod.ObjectMeta.Name, "test", "name")
+	assert.Equal(t, pod.ObjectMeta.Labels, map[string]string{"team": "platform", "heritage": "test"}, "labels")
+	assert.Equal(t, pod.ObjectMeta.Annotations["sidecar.istio.io/inject"], "false", "annotation")
+	assert.Equal(t, pod.Spec.NodeSelector, map[string]string{"pool": "builds", "disk": "ssd"}, "node selector")
+	assert.Equal(t, pod.Spec.RestartPolicy, api.RestartPolicyNever, "restart policy")
+	assert.True(t, *pod.Spec.SecurityContext.RunAsNonRoot, "security context was not kept")
+
+	assert.Equal(t, len(pod.Spec.Containers), 2, "number of containers")
+	assert.Equal(t, pod.Spec.Containers[0].Name, slugBuilderName, "builder container name")
+	assert.Equal(t, pod.Spec.Containers[0].Image, "slugbuilder", "builder container image")
+	assert.Equal(t, pod.Spec.Containers[1].Name, "sidecar", "sidecar container name")
+	checkForEnv(t, pod, "HTTP_PROXY", "http://proxy:3128")
+	checkForEnv(t, pod, "TAR_PATH", "tar")
+
+	volumes := map[string]bool{}
+	for _, volume := range pod.Spec.Volumes {
+		volumes[volume.Name] = true
+	}
+	assert.Equal(t, volumes, map[string]bool{"extra": true, objectStore: true, "test-build-env": true}, "volumes")
+
+	// the template must not be modified by building a pod from it
+	assert.Equal(t, len(template.Spec.Volumes), 1, "number of template volumes")
+	assert.Equal(t, template.Spec.Containers[0].Name, "builder", "template container name")
+}
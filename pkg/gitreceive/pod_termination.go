@@ -0,0 +1,78 @@
+package gitreceive
+
+import (
+	"fmt"
+	"syscall"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	podReasonEvicted          = "Evicted"
+	podReasonDeadlineExceeded = "DeadlineExceeded"
+	containerReasonOOMKilled  = "OOMKilled"
+)
+
+// podTerminationError explains why a finished builder pod didn't succeed. It returns nil if the
+// builder container of pod terminated with exit code 0 and the pod didn't fail.
+func podTerminationError(pod *api.Pod) error {
+	switch pod.Status.Reason {
+	case podReasonEvicted:
+		return fmt.Errorf("Build pod was evicted from node %s (%s). The node is likely short on resources, try pushing again.", pod.Spec.NodeName, pod.Status.Message)
+	case podReasonDeadlineExceeded:
+		return fmt.Errorf("Build pod ran for longer than its deadline and was stopped (%s).", pod.Status.Message)
+	}
+
+	reported := false
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		// only the builder container matters, sidecars from the pod template are ignored
+		if len(pod.Spec.Containers) > 0 && containerStatus.Name != pod.Spec.Containers[0].Name {
+			continue
+		}
+		reported = true
+		state := containerStatus.State.Terminated
+		if state == nil {
+			return fmt.Errorf("Build pod container %s never terminated, stopping build.", containerStatus.Name)
+		}
+		if state.ExitCode == 0 {
+			continue
+		}
+		if state.Reason == containerReasonOOMKilled {
+			return fmt.Errorf("Build pod ran out of memory (limit: %s), stopping build. Try raising it with 'deis config:set %s=<limit>'.", memoryLimit(pod, containerStatus.Name), appMemoryLimitKey)
+		}
+		if signal := terminationSignal(state); signal != 0 {
+			return fmt.Errorf("Build pod was killed by signal %d (%s), stopping build.", signal, signal)
+		}
+		return fmt.Errorf("Build pod exited with code %d, stopping build.", state.ExitCode)
+	}
+	// the pod may have failed before the builder container ran, or been rejected by its node
+	if !reported && pod.Status.Phase == api.PodFailed {
+		return fmt.Errorf("Build pod failed (%s: %s), stopping build.", pod.Status.Reason, pod.Status.Message)
+	}
+	return nil
+}
+
+// terminationSignal returns the signal that killed a container, or 0 if it exited by itself.
+func terminationSignal(state *api.ContainerStateTerminated) syscall.Signal {
+	if state.Signal != 0 {
+		return syscall.Signal(state.Signal)
+	}
+	// shells report a process killed by signal N with exit code 128+N
+	if state.ExitCode > 128 && state.ExitCode < 160 {
+		return syscall.Signal(state.ExitCode - 128)
+	}
+	return 0
+}
+
+// memoryLimit returns the memory limit of the named container of pod in a human readable form.
+func memoryLimit(pod *api.Pod, containerName string) string {
+	for _, container := range pod.Spec.Containers {
+		if container.Name != containerName {
+			continue
+		}
+		if limit, ok := container.Resources.Limits[api.ResourceMemory]; ok {
+			return limit.String()
+		}
+	}
+	return "none"
+}
@@ -0,0 +1,83 @@
+package gitreceive
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/arschles/assert"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+)
+
+func terminatedPod(state api.ContainerState) *api.Pod {
+	return &api.Pod{
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				{
+					Name: slugBuilderName,
+					Resources: api.ResourceRequirements{
+						Limits: api.ResourceList{api.ResourceMemory: resource.MustParse("512Mi")},
+					},
+				},
+			},
+		},
+		Status: api.PodStatus{
+			ContainerStatuses: []api.ContainerStatus{{Name: slugBuilderName, State: state}},
+		},
+	}
+}
+
+func TestPodTerminationErrorSuccess(t *testing.T) {
+	pod := terminatedPod(api.ContainerState{Terminated: &api.ContainerStateTerminated{ExitCode: 0}})
+	assert.NoErr(t, podTerminationError(pod))
+}
+
+func TestPodTerminationErrorExitCode(t *testing.T) {
+	pod := terminatedPod(api.ContainerState{Terminated: &api.ContainerStateTerminated{ExitCode: 1}})
+	assert.Err(t, podTerminationError(pod), errors.New("Build pod exited with code 1, stopping build."))
+}
+
+func TestPodTerminationErrorOOMKilled(t *testing.T) {
+	pod := terminatedPod(api.ContainerState{Terminated: &api.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"}})
+	assert.Err(t, podTerminationError(pod), errors.New("Build pod ran out of memory (limit: 512Mi), stopping build. Try raising it with 'deis config:set DEIS_BUILDER_MEMORY_LIMIT=<limit>'."))
+}
+
+func TestPodTerminationErrorSignal(t *testing.T) {
+	pod := terminatedPod(api.ContainerState{Terminated: &api.ContainerStateTerminated{ExitCode: 137}})
+	assert.Err(t, podTerminationError(pod), errors.New("Build pod was killed by signal 9 (killed), stopping build."))
+}
+
+func TestPodTerminationErrorNeverTerminated(t *testing.T) {
+	pod := terminatedPod(api.ContainerState{Running: &api.ContainerStateRunning{}})
+	assert.Err(t, podTerminationError(pod), errors.New("Build pod container deis-slugbuilder never terminated, stopping build."))
+}
+
+func TestPodTerminationErrorEvicted(t *testing.T) {
+	pod := terminatedPod(api.ContainerState{})
+	pod.Spec.NodeName = "node1"
+	pod.Status.Reason = "Evicted"
+	pod.Status.Message = "The node was low on memory."
+	assert.Err(t, podTerminationError(pod), errors.New("Build pod was evicted from node node1 (The node was low on memory.). The node is likely short on resources, try pushing again."))
+}
+
+func TestPodTerminationErrorDeadlineExceeded(t *testing.T) {
+	pod := terminatedPod(api.ContainerState{})
+	pod.Status.Reason = "DeadlineExceeded"
+	pod.Status.Message = "Pod was active on the node longer than specified deadline"
+	assert.Err(t, podTerminationError(pod), errors.New("Build pod ran for longer than its deadline and was stopped (Pod was active on the node longer than specified deadline)."))
+}
+
+func TestPodTerminationErrorFailedWithoutContainer(t *testing.T) {
+	pod := terminatedPod(api.ContainerState{})
+	pod.Status.Phase = api.PodFailed
+	pod.Status.ContainerStatuses = nil
+	pod.Status.Reason = "OutOfcpu"
+	pod.Status.Message = "Pod Node didn't have enough resource: cpu"
+	assert.Err(t, podTerminationError(pod), errors.New("Build pod failed (OutOfcpu: Pod Node didn't have enough resource: cpu), stopping build."))
+
+	// statuses of sidecar containers don't explain the failure
+	pod.Status.ContainerStatuses = []api.ContainerStatus{
+		{Name: "sidecar", State: api.ContainerState{Terminated: &api.ContainerStateTerminated{ExitCode: 0}}},
+	}
+	assert.Err(t, podTerminationError(pod), errors.New("Build pod failed (OutOfcpu: Pod Node didn't have enough resource: cpu), stopping build."))
+}
@@ -0,0 +1,117 @@
+package gitreceive
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const redactedValue = "[REDACTED]"
+
+// redactor hides the values of sensitive keys, such as passwords or tokens, from the debug logs.
+// A key is sensitive if it matches any of the configured patterns, case insensitively, or if it
+// names a build secret.
+type redactor struct {
+	patterns []*regexp.Regexp
+	keys     map[string]struct{}
+}
+
+// newRedactor returns a redactor for the comma separated regular expressions in patterns.
+func newRedactor(patterns string) (*redactor, error) {
+	r := &redactor{keys: make(map[string]struct{})}
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q (%s)", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// addBuildSecrets makes the build secrets listed in appValues sensitive.
+func (r *redactor) addBuildSecrets(appValues map[string]interface{}) {
+	for _, name := range strings.Split(appValueOrDefault(appValues, appBuildSecretsKey, ""), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			r.keys[name] = struct{}{}
+		}
+	}
+}
+
+func (r *redactor) sensitive(key string) bool {
+	if _, ok := r.keys[key]; ok {
+		return true
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// env returns a copy of env with the values of sensitive keys redacted.
+func (r *redactor) env(env map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(env))
+	for key, value := range env {
+		if r.sensitive(key) {
+			value = redactedValue
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+// strings returns a copy of values, such as registry details, with the values of sensitive keys
+// redacted.
+func (r *redactor) strings(values map[string]string) map[string]string {
+	redacted := make(map[string]string, len(values))
+	for key, value := range values {
+		if r.sensitive(key) {
+			value = redactedValue
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+// pod returns a copy of pod with the values of the sensitive env vars of its containers redacted.
+// The app config passed to docker builds in DOCKER_BUILD_ARGS is redacted key by key.
+func (r *redactor) pod(pod *api.Pod) (*api.Pod, error) {
+	redacted, err := copyPodTemplate(pod)
+	if err != nil {
+		return nil, err
+	}
+	for i := range redacted.Spec.Containers {
+		env := redacted.Spec.Containers[i].Env
+		for j := range env {
+			if env[j].Name == "DOCKER_BUILD_ARGS" {
+				env[j].Value = r.jsonObject(env[j].Value)
+			} else if r.sensitive(env[j].Name) {
+				env[j].Value = redactedValue
+			}
+		}
+	}
+	return &redacted, nil
+}
+
+// jsonObject redacts the values of the sensitive keys of a JSON object, or all of it if it can't
+// be parsed.
+func (r *redactor) jsonObject(raw string) string {
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return redactedValue
+	}
+	redacted, err := json.Marshal(r.env(values))
+	if err != nil {
+		return redactedValue
+	}
+	return string(redacted)
+}
@@ -0,0 +1,57 @@
+package gitreceive
+
+import (
+	"testing"
+
+	"github.com/arschles/assert"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestRedactorSensitive(t *testing.T) {
+	r, err := newRedactor("PASSWORD, token,^AWS_")
+	assert.NoErr(t, err)
+	r.addBuildSecrets(map[string]interface{}{appBuildSecretsKey: "NPM_RC"})
+
+	for _, key := range []string{"DEIS_REGISTRY_PASSWORD", "GITHUB_TOKEN", "AWS_REGION", "NPM_RC"} {
+		assert.True(t, r.sensitive(key), "%s is not sensitive", key)
+	}
+	for _, key := range []string{"PORT", "MY_AWS_REGION", "DEIS_REGISTRY_HOSTNAME"} {
+		assert.False(t, r.sensitive(key), "%s is sensitive", key)
+	}
+
+	_, err = newRedactor("PASSWORD,(")
+	assert.True(t, err != nil, "no error for an invalid pattern")
+}
+
+func TestRedactorMaps(t *testing.T) {
+	r, err := newRedactor("PASSWORD")
+	assert.NoErr(t, err)
+
+	env := map[string]interface{}{"DB_PASSWORD": "hunter2", "PORT": 5000}
+	assert.Equal(t, r.env(env), map[string]interface{}{"DB_PASSWORD": redactedValue, "PORT": 5000}, "redacted env")
+	assert.Equal(t, env["DB_PASSWORD"], "hunter2", "original env")
+
+	details := map[string]string{"DEIS_REGISTRY_PASSWORD": "hunter2", "DEIS_REGISTRY_HOSTNAME": "quay.io"}
+	assert.Equal(t, r.strings(details), map[string]string{"DEIS_REGISTRY_PASSWORD": redactedValue, "DEIS_REGISTRY_HOSTNAME": "quay.io"}, "redacted registry details")
+}
+
+func TestRedactorPod(t *testing.T) {
+	r, err := newRedactor("PASSWORD")
+	assert.NoErr(t, err)
+
+	pod := &api.Pod{Spec: api.PodSpec{Containers: []api.Container{{
+		Env: []api.EnvVar{
+			{Name: "DEIS_REGISTRY_PASSWORD", Value: "hunter2"},
+			{Name: "DOCKER_BUILD_ARGS", Value: `{"DB_PASSWORD":"hunter2","PORT":"5000"}`},
+			{Name: "IMG_NAME", Value: "myapp:git-c3b4e4ba"},
+		},
+	}}}}
+	redacted, err := r.pod(pod)
+	assert.NoErr(t, err)
+
+	env := redacted.Spec.Containers[0].Env
+	assert.Equal(t, env[0].Value, redactedValue, "registry password")
+	assert.Equal(t, env[1].Value, `{"DB_PASSWORD":"[REDACTED]","PORT":"5000"}`, "build args")
+	assert.Equal(t, env[2].Value, "myapp:git-c3b4e4ba", "image name")
+	assert.Equal(t, pod.Spec.Containers[0].Env[0].Value, "hunter2", "original pod")
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/deis/builder/pkg/storage"
@@ -12,7 +13,8 @@ import (
 )
 
 const (
-	registrySecret = "registry-secret"
+	registrySecret             = "registry-secret"
+	registryDockerConfigSecret = "registry-dockerconfigjson"
 )
 
 func getDetailsFromRegistrySecret(secretGetter client.SecretsInterface, secret string) (map[string]string, error) {
@@ -27,99 +29,287 @@ func getDetailsFromRegistrySecret(secretGetter client.SecretsInterface, secret s
 	return regDetails, nil
 }
 
-func getDetailsFromDockerConfigSecret(secretGetter client.SecretsInterface, secret string) (map[string]string, error) {
+// dockerConfigAuth is an entry of the auths of a dockerconfigjson secret.
+type dockerConfigAuth struct {
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// registryHost strips the scheme and path of a registry hostname, as found in the auths of a
+// dockerconfigjson secret, so that "https://index.docker.io/v1/" and "index.docker.io" match.
+func registryHost(hostname string) string {
+	host := hostname
+	if idx := strings.Index(host, "://"); idx >= 0 {
+		host = host[idx+3:]
+	}
+	if idx := strings.Index(host, "/"); idx >= 0 {
+		host = host[:idx]
+	}
+	return strings.ToLower(host)
+}
+
+// getDetailsFromDockerConfigSecret returns the credentials for hostname in the dockerconfigjson
+// secret. If hostname is empty, the secret must hold the credentials of a single registry.
+func getDetailsFromDockerConfigSecret(secretGetter client.SecretsInterface, secret, hostname string) (map[string]string, error) {
 	configSecret, err := secretGetter.Get(secret)
 	if err != nil {
 		return nil, err
 	}
 	dockerConfigJSONBytes := configSecret.Data[api.DockerConfigJsonKey]
-	var secretData map[string]interface{}
+	var secretData struct {
+		Auths map[string]dockerConfigAuth `json:"auths"`
+	}
 	if err = json.Unmarshal(dockerConfigJSONBytes, &secretData); err != nil {
 		return nil, err
 	}
 
-	var authdata map[string]interface{}
-	var hostname string
-	for key, value := range secretData["auths"].(map[string]interface{}) {
-		hostname = key
-		authdata = value.(map[string]interface{})
-	}
-	token := authdata["auth"].(string)
-	decodedToken, err := base64.StdEncoding.DecodeString(token)
-	if err != nil {
-		return nil, err
+	var authHostname string
+	var authData dockerConfigAuth
+	if hostname == "" {
+		if len(secretData.Auths) != 1 {
+			return nil, fmt.Errorf("docker config secret %s holds the credentials of %d registries, the registry hostname must be configured to pick one", secret, len(secretData.Auths))
+		}
+		for key, value := range secretData.Auths {
+			authHostname, authData = key, value
+		}
+	} else {
+		found := false
+		for key, value := range secretData.Auths {
+			if registryHost(key) == registryHost(hostname) {
+				authHostname, authData, found = key, value, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no credentials for registry %s in docker config secret %s", hostname, secret)
+		}
 	}
-	parts := strings.SplitN(string(decodedToken), ":", 2)
-	if len(parts) != 2 {
-		return nil, errors.New("Invalid token in docker config secret")
+
+	user, password := authData.Username, authData.Password
+	if authData.Auth != "" {
+		decodedToken, err := base64.StdEncoding.DecodeString(authData.Auth)
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.SplitN(string(decodedToken), ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("Invalid token in docker config secret")
+		}
+		user, password = parts[0], parts[1]
 	}
-	user := parts[0]
-	password := parts[1]
 	regDetails := make(map[string]string)
 	regDetails["DEIS_REGISTRY_USERNAME"] = user
 	regDetails["DEIS_REGISTRY_PASSWORD"] = password
-	regDetails["DEIS_REGISTRY_HOSTNAME"] = hostname
+	regDetails["DEIS_REGISTRY_HOSTNAME"] = authHostname
 	return regDetails, nil
 }
 
-func getRegistryDetails(kubeClient client.SecretsNamespacer, image *string, registryLocation, namespace, registrySecretPrefix string) (map[string]string, error) {
-	registryConfigSecretInterface := kubeClient.Secrets(*image)
-	privateRegistrySecretInterface := kubeClient.Secrets(namespace)
+// registryOptions configures where and how images are pushed when the registry isn't on-cluster.
+type registryOptions struct {
+	// location selects the registry provider
+	location string
+	// namespace is the namespace of the builder, holding its registry secrets
+	namespace string
+	// secretPrefix prefixes the name of the dockerconfigjson secrets the controller creates in the
+	// namespace of the app
+	secretPrefix string
+	// hostname picks the credentials of a registry in a dockerconfigjson secret
+	hostname string
+	// organization is the namespace of the images in the registry, if any
+	organization string
+}
+
+// registryCredentials are the credentials builder pods push to a registry with.
+type registryCredentials struct {
+	hostname string
+	username string
+	password string
+}
+
+// dockerConfigCredentials returns the credentials in the registry env vars read from a
+// dockerconfigjson secret by getDetailsFromDockerConfigSecret.
+func dockerConfigCredentials(registryEnv map[string]string) registryCredentials {
+	return registryCredentials{
+		hostname: registryEnv["DEIS_REGISTRY_HOSTNAME"],
+		username: registryEnv["DEIS_REGISTRY_USERNAME"],
+		password: registryEnv["DEIS_REGISTRY_PASSWORD"],
+	}
+}
+
+// registryProvider knows how to push images to a kind of registry.
+type registryProvider interface {
+	// details returns the env vars builder pods need to push to the registry, and prefixes image,
+	// the name of the app, with the registry hostname and organization.
+	details(kubeClient client.SecretsNamespacer, image *string) (map[string]string, error)
+	// credentials returns the hostname of the registry and the credentials builder pods of app
+	// push to it with. Unlike details, it never changes anything in the registry.
+	credentials(kubeClient client.SecretsNamespacer, app string) (registryCredentials, error)
+}
+
+// registryProviders holds a constructor for every registry provider, by registry location. The
+// on-cluster registry needs no provider.
+var registryProviders = map[string]func(opts registryOptions) registryProvider{
+	"off-cluster":      func(opts registryOptions) registryProvider { return offClusterRegistry{opts} },
+	"ecr":              func(opts registryOptions) registryProvider { return ecrRegistry{opts} },
+	"gcr":              func(opts registryOptions) registryProvider { return gcrRegistry{opts} },
+	"dockerconfigjson": func(opts registryOptions) registryProvider { return dockerConfigRegistry{opts} },
+}
+
+// newRegistryProvider returns the provider for opts.location, or an error if there is none.
+func newRegistryProvider(opts registryOptions) (registryProvider, error) {
+	newProvider, ok := registryProviders[opts.location]
+	if !ok {
+		return nil, fmt.Errorf("unknown registry location %q", opts.location)
+	}
+	return newProvider(opts), nil
+}
+
+// offClusterRegistry pushes to a registry described by the registry-secret of the builder.
+type offClusterRegistry struct {
+	opts registryOptions
+}
+
+func (r offClusterRegistry) details(kubeClient client.SecretsNamespacer, image *string) (map[string]string, error) {
+	regSecretData, err := getDetailsFromRegistrySecret(kubeClient.Secrets(r.opts.namespace), registrySecret)
+	if err != nil {
+		return nil, err
+	}
 	registryEnv := make(map[string]string)
-	var regSecretData map[string]string
-	var err error
-	if registryLocation == "off-cluster" {
-		regSecretData, err = getDetailsFromRegistrySecret(privateRegistrySecretInterface, registrySecret)
-		if err != nil {
-			return nil, err
-		}
-		for key, value := range regSecretData {
-			registryEnv["DEIS_REGISTRY_"+strings.ToUpper(key)] = value
-		}
-		if registryEnv["DEIS_REGISTRY_ORGANIZATION"] != "" {
-			*image = registryEnv["DEIS_REGISTRY_ORGANIZATION"] + "/" + *image
-		}
-		if registryEnv["DEIS_REGISTRY_HOSTNAME"] != "" {
-			*image = registryEnv["DEIS_REGISTRY_HOSTNAME"] + "/" + *image
-		}
-	} else if registryLocation == "ecr" {
-		registryEnv, err = getDetailsFromDockerConfigSecret(registryConfigSecretInterface, registrySecretPrefix+"-"+registryLocation)
-		if err != nil {
-			return nil, err
-		}
+	for key, value := range regSecretData {
+		registryEnv["DEIS_REGISTRY_"+strings.ToUpper(key)] = value
+	}
+	if registryEnv["DEIS_REGISTRY_ORGANIZATION"] != "" {
+		*image = registryEnv["DEIS_REGISTRY_ORGANIZATION"] + "/" + *image
+	}
+	if registryEnv["DEIS_REGISTRY_HOSTNAME"] != "" {
+		*image = registryEnv["DEIS_REGISTRY_HOSTNAME"] + "/" + *image
+	}
+	return registryEnv, nil
+}
 
-		regSecretData, err = getDetailsFromRegistrySecret(privateRegistrySecretInterface, registrySecret)
-		if err != nil {
-			return nil, err
-		}
-		err = storage.CreateImageRepo(*image, regSecretData)
-		if err != nil {
-			return nil, err
-		}
-		hostname := strings.Replace(registryEnv["DEIS_REGISTRY_HOSTNAME"], "https://", "", 1)
-		*image = hostname + "/" + *image
+func (r offClusterRegistry) credentials(kubeClient client.SecretsNamespacer, app string) (registryCredentials, error) {
+	regSecretData, err := getDetailsFromRegistrySecret(kubeClient.Secrets(r.opts.namespace), registrySecret)
+	if err != nil {
+		return registryCredentials{}, err
+	}
+	return registryCredentials{
+		hostname: regSecretData["hostname"],
+		username: regSecretData["username"],
+		password: regSecretData["password"],
+	}, nil
+}
 
-	} else if registryLocation == "gcr" {
-		registryEnv, err = getDetailsFromDockerConfigSecret(registryConfigSecretInterface, registrySecretPrefix+"-"+registryLocation)
-		if err != nil {
-			return nil, err
-		}
+// ecrRegistry pushes to Amazon EC2 Container Registry, creating the repository of the image if
+// needed.
+type ecrRegistry struct {
+	opts registryOptions
+}
 
-		regSecretData, err = getDetailsFromRegistrySecret(privateRegistrySecretInterface, registrySecret)
-		if err != nil {
-			return nil, err
-		}
-		var key struct {
-			ProjectID string `json:"project_id"`
-		}
-		jsonKey := []byte(regSecretData["key.json"])
-		if err := json.Unmarshal(jsonKey, &key); err != nil {
-			return nil, err
-		}
-		hostname := strings.Replace(registryEnv["DEIS_REGISTRY_HOSTNAME"], "https://", "", 1)
-		projectID := strings.Replace(key.ProjectID, ":", "/", -1)
-		registryEnv["DEIS_REGISTRY_GCS_PROJ_ID"] = projectID
-		*image = strings.Replace(hostname, "https://", "", 1) + "/" + projectID + "/" + *image
+func (r ecrRegistry) details(kubeClient client.SecretsNamespacer, image *string) (map[string]string, error) {
+	registryEnv, err := getDetailsFromDockerConfigSecret(kubeClient.Secrets(*image), r.opts.secretPrefix+"-"+r.opts.location, "")
+	if err != nil {
+		return nil, err
+	}
+
+	regSecretData, err := getDetailsFromRegistrySecret(kubeClient.Secrets(r.opts.namespace), registrySecret)
+	if err != nil {
+		return nil, err
 	}
+	err = storage.CreateImageRepo(*image, regSecretData)
+	if err != nil {
+		return nil, err
+	}
+	hostname := strings.Replace(registryEnv["DEIS_REGISTRY_HOSTNAME"], "https://", "", 1)
+	*image = hostname + "/" + *image
 	return registryEnv, nil
 }
+
+func (r ecrRegistry) credentials(kubeClient client.SecretsNamespacer, app string) (registryCredentials, error) {
+	registryEnv, err := getDetailsFromDockerConfigSecret(kubeClient.Secrets(app), r.opts.secretPrefix+"-"+r.opts.location, "")
+	if err != nil {
+		return registryCredentials{}, err
+	}
+	return dockerConfigCredentials(registryEnv), nil
+}
+
+// gcrRegistry pushes to Google Container Registry, under the project of the service account of
+// the builder.
+type gcrRegistry struct {
+	opts registryOptions
+}
+
+func (r gcrRegistry) details(kubeClient client.SecretsNamespacer, image *string) (map[string]string, error) {
+	registryEnv, err := getDetailsFromDockerConfigSecret(kubeClient.Secrets(*image), r.opts.secretPrefix+"-"+r.opts.location, "")
+	if err != nil {
+		return nil, err
+	}
+
+	regSecretData, err := getDetailsFromRegistrySecret(kubeClient.Secrets(r.opts.namespace), registrySecret)
+	if err != nil {
+		return nil, err
+	}
+	var key struct {
+		ProjectID string `json:"project_id"`
+	}
+	jsonKey := []byte(regSecretData["key.json"])
+	if err := json.Unmarshal(jsonKey, &key); err != nil {
+		return nil, err
+	}
+	hostname := strings.Replace(registryEnv["DEIS_REGISTRY_HOSTNAME"], "https://", "", 1)
+	projectID := strings.Replace(key.ProjectID, ":", "/", -1)
+	registryEnv["DEIS_REGISTRY_GCS_PROJ_ID"] = projectID
+	*image = hostname + "/" + projectID + "/" + *image
+	return registryEnv, nil
+}
+
+func (r gcrRegistry) credentials(kubeClient client.SecretsNamespacer, app string) (registryCredentials, error) {
+	registryEnv, err := getDetailsFromDockerConfigSecret(kubeClient.Secrets(app), r.opts.secretPrefix+"-"+r.opts.location, "")
+	if err != nil {
+		return registryCredentials{}, err
+	}
+	return dockerConfigCredentials(registryEnv), nil
+}
+
+// dockerConfigRegistry pushes to any registry accepting the credentials of a dockerconfigjson
+// secret, such as Docker Hub, Quay, Harbor or Azure Container Registry. The secret, named
+// registry-dockerconfigjson, lives in the namespace of the builder and may hold the credentials
+// of several registries, in which case the configured hostname picks one.
+type dockerConfigRegistry struct {
+	opts registryOptions
+}
+
+func (r dockerConfigRegistry) details(kubeClient client.SecretsNamespacer, image *string) (map[string]string, error) {
+	registryEnv, err := getDetailsFromDockerConfigSecret(kubeClient.Secrets(r.opts.namespace), registryDockerConfigSecret, r.opts.hostname)
+	if err != nil {
+		return nil, err
+	}
+	if r.opts.organization != "" {
+		*image = r.opts.organization + "/" + *image
+		registryEnv["DEIS_REGISTRY_ORGANIZATION"] = r.opts.organization
+	}
+	host := registryHost(registryEnv["DEIS_REGISTRY_HOSTNAME"])
+	if host == "index.docker.io" {
+		host = "docker.io"
+	}
+	*image = host + "/" + *image
+	return registryEnv, nil
+}
+
+func (r dockerConfigRegistry) credentials(kubeClient client.SecretsNamespacer, app string) (registryCredentials, error) {
+	registryEnv, err := getDetailsFromDockerConfigSecret(kubeClient.Secrets(r.opts.namespace), registryDockerConfigSecret, r.opts.hostname)
+	if err != nil {
+		return registryCredentials{}, err
+	}
+	return dockerConfigCredentials(registryEnv), nil
+}
+
+// getRegistryDetails returns the env vars builder pods need to push to the registry at
+// opts.location, and prefixes image with the registry hostname and organization.
+func getRegistryDetails(kubeClient client.SecretsNamespacer, image *string, opts registryOptions) (map[string]string, error) {
+	provider, err := newRegistryProvider(opts)
+	if err != nil {
+		return nil, err
+	}
+	return provider.details(kubeClient, image)
+}
@@ -0,0 +1,172 @@
+package gitreceive
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/deis/builder/pkg/storage"
+	"github.com/deis/pkg/log"
+	"github.com/docker/distribution/context"
+)
+
+const (
+	onClusterRegistry = "on-cluster"
+
+	// registryMirrorsEnv lists, comma separated, the images builder pods push in addition to
+	// IMG_NAME. The credentials of the Nth mirror are in the DEIS_REGISTRY_MIRROR_<N>_* env vars.
+	registryMirrorsEnv = "DEIS_REGISTRY_MIRRORS"
+	// pushResultsPath is the env var holding the object storage key builder pods upload the
+	// results of their pushes to, as pushResults.
+	pushResultsPath = "PUSH_RESULTS_PATH"
+	pushResultsName = "push-results.json"
+)
+
+// registryTarget is a registry builds push to. The primary one is DEIS_REGISTRY_LOCATION, shared
+// with the controller, and mirrors are the entries of DEIS_REGISTRY_MIRROR_LOCATIONS, written as
+// "location" or "location=hostname", the hostname picking the credentials of dockerconfigjson
+// registries.
+type registryTarget struct {
+	location string
+	hostname string
+}
+
+// registryMirror is a registry the image of a Dockerfile build is mirrored to.
+type registryMirror struct {
+	location string
+	image    string
+	env      map[string]string
+}
+
+// pushResults are the outcome of the pushes of a Dockerfile build, as uploaded by the builder pod
+// to PUSH_RESULTS_PATH.
+type pushResults struct {
+	// Digest is the digest of the image pushed to the primary registry
+	Digest  string       `json:"digest"`
+	Mirrors []pushResult `json:"mirrors"`
+}
+
+// pushResult is the outcome of the push of an image to a mirror.
+type pushResult struct {
+	Image  string `json:"image"`
+	Digest string `json:"digest,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// parseRegistryTargets parses the comma separated, ordered list of registry mirrors in locations.
+func parseRegistryTargets(locations string) ([]registryTarget, error) {
+	var targets []registryTarget
+	for _, entry := range strings.Split(locations, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target := registryTarget{location: entry}
+		if idx := strings.Index(entry, "="); idx >= 0 {
+			target.location, target.hostname = entry[:idx], entry[idx+1:]
+		}
+		if target.location == onClusterRegistry {
+			return nil, fmt.Errorf("the %s registry can't be a registry mirror", onClusterRegistry)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// registryTargets returns the registries builds push to: the primary registry, the one released
+// images are pulled from, followed by the mirrors.
+func registryTargets(conf *Config) ([]registryTarget, error) {
+	if conf.RegistryLocation == "" {
+		return nil, fmt.Errorf("no registry location configured")
+	}
+	mirrors, err := parseRegistryTargets(conf.RegistryMirrors)
+	if err != nil {
+		return nil, err
+	}
+	return append([]registryTarget{{location: conf.RegistryLocation}}, mirrors...), nil
+}
+
+func pushResultsKey(pushKey string) string {
+	return pushKey + "/" + pushResultsName
+}
+
+// registryMirrors returns the registries of DEIS_REGISTRY_MIRROR_LOCATIONS, with the image to push
+// to each of them and the credentials to push with.
+func registryMirrors(b *buildContext) ([]registryMirror, error) {
+	targets, err := parseRegistryTargets(b.conf.RegistryMirrors)
+	if err != nil {
+		return nil, err
+	}
+	var mirrors []registryMirror
+	for _, target := range targets {
+		image, env, err := targetRegistryDetails(b, target)
+		if err != nil {
+			return nil, err
+		}
+		mirrors = append(mirrors, registryMirror{location: target.location, image: image, env: env})
+	}
+	return mirrors, nil
+}
+
+// mirrorsEnv returns the env vars telling builder pods to push to mirrors, renaming the
+// DEIS_REGISTRY_* details of the Nth mirror to DEIS_REGISTRY_MIRROR_<N>_*.
+func mirrorsEnv(mirrors []registryMirror) map[string]string {
+	env := make(map[string]string)
+	images := make([]string, len(mirrors))
+	for i, mirror := range mirrors {
+		images[i] = mirror.image
+		for key, value := range mirror.env {
+			env[fmt.Sprintf("DEIS_REGISTRY_MIRROR_%d_%s", i+1, strings.TrimPrefix(key, "DEIS_REGISTRY_"))] = value
+		}
+	}
+	env[registryMirrorsEnv] = strings.Join(images, ",")
+	return env
+}
+
+// getPushResults reads the push results uploaded by the builder pod to key.
+func getPushResults(getter storage.ObjectGetter, key string) (*pushResults, error) {
+	raw, err := getter.GetContent(context.Background(), key)
+	if err != nil {
+		return nil, fmt.Errorf("error in reading %s (%s)", key, err)
+	}
+	results := new(pushResults)
+	if err := json.Unmarshal(raw, results); err != nil {
+		return nil, fmt.Errorf("push results %s are malformed (%s)", key, err)
+	}
+	return results, nil
+}
+
+// reportPushes logs whether image was pushed to the primary registry and to each mirror, from the
+// push results uploaded by the builder pod to key.
+func reportPushes(getter storage.ObjectGetter, key, image string, mirrors []registryMirror) {
+	results := make(map[string]pushResult)
+	pushResults, err := getPushResults(getter, key)
+	switch {
+	case err != nil:
+		log.Info("Unable to read the registry push results (%s)", err)
+		log.Info("Unknown result of the push of %s to the primary registry", image)
+	case pushResults.Digest != "":
+		log.Info("Pushed %s@%s", image, pushResults.Digest)
+	default:
+		log.Info("Pushed %s", image)
+	}
+	if pushResults != nil {
+		for _, result := range pushResults.Mirrors {
+			results[result.Image] = result
+		}
+	}
+
+	for _, mirror := range mirrors {
+		result, ok := results[mirror.image]
+		switch {
+		case !ok:
+			log.Info("Unknown result of the push of %s to the %s registry", mirror.image, mirror.location)
+		case result.Error != "":
+			log.Info("Failed to push %s to the %s registry (%s)", mirror.image, mirror.location, result.Error)
+		case result.Digest != "":
+			log.Info("Pushed %s@%s to the %s registry", mirror.image, result.Digest, mirror.location)
+		default:
+			log.Info("Pushed %s to the %s registry", mirror.image, mirror.location)
+		}
+	}
+}
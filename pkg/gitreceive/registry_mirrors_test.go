@@ -0,0 +1,76 @@
+package gitreceive
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/deis/builder/pkg/storage"
+	"github.com/docker/distribution/context"
+)
+
+func TestParseRegistryTargets(t *testing.T) {
+	targets, err := parseRegistryTargets("")
+	assert.NoErr(t, err)
+	assert.Equal(t, len(targets), 0, "number of targets")
+
+	targets, err = parseRegistryTargets("ecr, dockerconfigjson=quay.io,")
+	assert.NoErr(t, err)
+	expected := []registryTarget{{location: "ecr"}, {location: "dockerconfigjson", hostname: "quay.io"}}
+	assert.Equal(t, targets, expected, "targets")
+
+	_, err = parseRegistryTargets("gcr,on-cluster")
+	assert.True(t, err != nil, "no error returned for an on-cluster mirror")
+}
+
+func TestRegistryTargets(t *testing.T) {
+	targets, err := registryTargets(&Config{RegistryLocation: "on-cluster"})
+	assert.NoErr(t, err)
+	assert.Equal(t, targets, []registryTarget{{location: "on-cluster"}}, "targets")
+
+	targets, err = registryTargets(&Config{RegistryLocation: "gcr", RegistryMirrors: "dockerconfigjson=quay.io"})
+	assert.NoErr(t, err)
+	expected := []registryTarget{{location: "gcr"}, {location: "dockerconfigjson", hostname: "quay.io"}}
+	assert.Equal(t, targets, expected, "targets")
+
+	_, err = registryTargets(&Config{})
+	assert.True(t, err != nil, "no error returned without registry location")
+}
+
+func TestMirrorsEnv(t *testing.T) {
+	mirrors := []registryMirror{
+		{
+			location: "dockerconfigjson",
+			image:    "quay.io/myorg/myapp:git-c3b4e4ba",
+			env:      map[string]string{"DEIS_REGISTRY_USERNAME": "quayuser", "DEIS_REGISTRY_PASSWORD": "quaypassword"},
+		},
+		{
+			location: "gcr",
+			image:    "gcr.io/myproject/myapp:git-c3b4e4ba",
+			env:      map[string]string{"DEIS_REGISTRY_GCS_PROJ_ID": "myproject"},
+		},
+	}
+	expected := map[string]string{
+		registryMirrorsEnv:                   "quay.io/myorg/myapp:git-c3b4e4ba,gcr.io/myproject/myapp:git-c3b4e4ba",
+		"DEIS_REGISTRY_MIRROR_1_USERNAME":    "quayuser",
+		"DEIS_REGISTRY_MIRROR_1_PASSWORD":    "quaypassword",
+		"DEIS_REGISTRY_MIRROR_2_GCS_PROJ_ID": "myproject",
+	}
+	assert.Equal(t, mirrorsEnv(mirrors), expected, "mirrors env")
+}
+
+func TestReportPushes(t *testing.T) {
+	getter := &storage.FakeObjectGetter{
+		Fn: func(context.Context, string) ([]byte, error) {
+			return []byte(`{"digest": "sha256:abc", "mirrors": [{"image": "quay.io/myorg/myapp:git-c3b4e4ba", "digest": "sha256:abc"}]}`), nil
+		},
+	}
+	mirrors := []registryMirror{{location: "dockerconfigjson", image: "quay.io/myorg/myapp:git-c3b4e4ba"}}
+	reportPushes(getter, pushResultsKey("home/myapp:git-c3b4e4ba/push"), "myapp", mirrors)
+	assert.Equal(t, getter.Calls[0].Path, "home/myapp:git-c3b4e4ba/push/push-results.json", "object key")
+
+	getter.Fn = func(context.Context, string) ([]byte, error) {
+		return nil, errors.New("test error")
+	}
+	reportPushes(getter, pushResultsKey("home/myapp:git-c3b4e4ba/push"), "myapp", mirrors)
+}
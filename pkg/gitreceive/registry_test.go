@@ -48,7 +48,7 @@ func TestGetDetailsFromDockerConfigSecretErr(t *testing.T) {
 			return &api.Secret{}, expectedErr
 		},
 	}
-	_, err := getDetailsFromDockerConfigSecret(getter, testSecret)
+	_, err := getDetailsFromDockerConfigSecret(getter, testSecret, "")
 	assert.Err(t, expectedErr, err)
 }
 
@@ -61,7 +61,7 @@ func TestGetDetailsFromDockerConfigSecretJsonErr(t *testing.T) {
 			return &secret, nil
 		},
 	}
-	_, err := getDetailsFromDockerConfigSecret(getter, testSecret)
+	_, err := getDetailsFromDockerConfigSecret(getter, testSecret, "")
 	assert.Equal(t, expectedErr.Error(), err.Error(), "error")
 }
 
@@ -85,7 +85,7 @@ func TestGetDetailsFromDockerConfigSecretTokenerr(t *testing.T) {
 			return &secret, nil
 		},
 	}
-	_, err := getDetailsFromDockerConfigSecret(getter, testSecret)
+	_, err := getDetailsFromDockerConfigSecret(getter, testSecret, "")
 	assert.Err(t, expectedErr, err)
 }
 
@@ -110,7 +110,7 @@ func TestGetDetailsFromDockerConfigSecretSuccess(t *testing.T) {
 			return &secret, nil
 		},
 	}
-	regData, err := getDetailsFromDockerConfigSecret(getter, testSecret)
+	regData, err := getDetailsFromDockerConfigSecret(getter, testSecret, "")
 	assert.NoErr(t, err)
 	assert.Equal(t, expectedData, regData, "registry details")
 
@@ -130,7 +130,7 @@ func TestGetRegistryDetailsOffclusterErr(t *testing.T) {
 		},
 	}
 	image := "test-image"
-	_, err := getRegistryDetails(kubeClient, &image, "off-cluster", deisNamespace, "private-registry")
+	_, err := getRegistryDetails(kubeClient, &image, registryOptions{location: "off-cluster", namespace: deisNamespace, secretPrefix: "private-registry"})
 	assert.Err(t, err, expectedErr)
 }
 
@@ -151,7 +151,7 @@ func TestGetRegistryDetailsOffclusterSuccess(t *testing.T) {
 		},
 	}
 	image := "test-image"
-	regDetails, err := getRegistryDetails(kubeClient, &image, "off-cluster", deisNamespace, "private-registry")
+	regDetails, err := getRegistryDetails(kubeClient, &image, registryOptions{location: "off-cluster", namespace: deisNamespace, secretPrefix: "private-registry"})
 	assert.NoErr(t, err)
 	assert.Equal(t, expectedData, regDetails, "registry details")
 	assert.Equal(t, expectedImage, image, "image")
@@ -204,7 +204,7 @@ func TestGetRegistryDetailsGCRSuccess(t *testing.T) {
 	expectedImage := "test.io/deis-test/test-image"
 
 	image := "test-image"
-	regDetails, err := getRegistryDetails(kubeClient, &image, "gcr", deisNamespace, "private-registry")
+	regDetails, err := getRegistryDetails(kubeClient, &image, registryOptions{location: "gcr", namespace: deisNamespace, secretPrefix: "private-registry"})
 
 	assert.NoErr(t, err)
 	assert.Equal(t, expectedData, regDetails, "registry details")
@@ -235,7 +235,7 @@ func TestGetRegistryDetailsGCRConfigErr(t *testing.T) {
 	}
 
 	image := "test-image"
-	_, err := getRegistryDetails(kubeClient, &image, "gcr", deisNamespace, "private-registry")
+	_, err := getRegistryDetails(kubeClient, &image, registryOptions{location: "gcr", namespace: deisNamespace, secretPrefix: "private-registry"})
 
 	assert.Err(t, err, expectedErr)
 }
@@ -278,7 +278,7 @@ func TestGetRegistryDetailsGCRSecretErr(t *testing.T) {
 	}
 
 	image := "test-image"
-	_, err := getRegistryDetails(kubeClient, &image, "gcr", deisNamespace, "private-registry")
+	_, err := getRegistryDetails(kubeClient, &image, registryOptions{location: "gcr", namespace: deisNamespace, secretPrefix: "private-registry"})
 
 	assert.Err(t, err, expectedErr)
 }
@@ -323,7 +323,89 @@ func TestGetRegistryDetailsGCRJsonErr(t *testing.T) {
 	}
 
 	image := "test-image"
-	_, err := getRegistryDetails(kubeClient, &image, "gcr", deisNamespace, "private-registry")
+	_, err := getRegistryDetails(kubeClient, &image, registryOptions{location: "gcr", namespace: deisNamespace, secretPrefix: "private-registry"})
 
 	assert.Equal(t, expectedErr.Error(), err.Error(), "error")
 }
+
+func TestGetDetailsFromDockerConfigSecretHostname(t *testing.T) {
+	quayToken := base64.StdEncoding.EncodeToString([]byte("quayuser:quaypassword"))
+	auth := []byte(`
+    {
+    "auths": {
+              "https://index.docker.io/v1/": {
+                  "username": "hubuser",
+                  "password": "hubpassword"
+              },
+              "quay.io": {
+                  "auth": "` + quayToken + `"
+              }
+          }
+    }
+`)
+	secret := api.Secret{Data: map[string][]byte{api.DockerConfigJsonKey: auth}}
+	getter := &k8s.FakeSecret{
+		FnGet: func(string) (*api.Secret, error) {
+			return &secret, nil
+		},
+	}
+
+	regData, err := getDetailsFromDockerConfigSecret(getter, testSecret, "https://quay.io")
+	assert.NoErr(t, err)
+	expectedData := map[string]string{"DEIS_REGISTRY_USERNAME": "quayuser", "DEIS_REGISTRY_PASSWORD": "quaypassword", "DEIS_REGISTRY_HOSTNAME": "quay.io"}
+	assert.Equal(t, regData, expectedData, "registry details")
+
+	regData, err = getDetailsFromDockerConfigSecret(getter, testSecret, "index.docker.io")
+	assert.NoErr(t, err)
+	expectedData = map[string]string{"DEIS_REGISTRY_USERNAME": "hubuser", "DEIS_REGISTRY_PASSWORD": "hubpassword", "DEIS_REGISTRY_HOSTNAME": "https://index.docker.io/v1/"}
+	assert.Equal(t, regData, expectedData, "registry details")
+
+	_, err = getDetailsFromDockerConfigSecret(getter, testSecret, "")
+	assert.True(t, err != nil, "no error returned for several registries without hostname")
+	_, err = getDetailsFromDockerConfigSecret(getter, testSecret, "myregistry.azurecr.io")
+	assert.True(t, err != nil, "no error returned for a registry without credentials")
+}
+
+func TestGetRegistryDetailsDockerConfigSuccess(t *testing.T) {
+	auth := []byte(`{"auths": {"https://index.docker.io/v1/": {"username": "hubuser", "password": "hubpassword"}}}`)
+	secret := api.Secret{Data: map[string][]byte{api.DockerConfigJsonKey: auth}}
+	var secretName string
+	getter := &k8s.FakeSecret{
+		FnGet: func(name string) (*api.Secret, error) {
+			secretName = name
+			return &secret, nil
+		},
+	}
+	kubeClient := &k8s.FakeSecretsNamespacer{
+		Fn: func(namespace string) client.SecretsInterface {
+			assert.Equal(t, namespace, deisNamespace, "secret namespace")
+			return getter
+		},
+	}
+
+	image := "test-image"
+	regDetails, err := getRegistryDetails(kubeClient, &image, registryOptions{location: "dockerconfigjson", namespace: deisNamespace, organization: "myorg"})
+	assert.NoErr(t, err)
+	assert.Equal(t, secretName, registryDockerConfigSecret, "secret name")
+	assert.Equal(t, regDetails["DEIS_REGISTRY_USERNAME"], "hubuser", "registry username")
+	assert.Equal(t, regDetails["DEIS_REGISTRY_ORGANIZATION"], "myorg", "registry organization")
+	assert.Equal(t, image, "docker.io/myorg/test-image", "image")
+
+	provider, err := newRegistryProvider(registryOptions{location: "dockerconfigjson", namespace: deisNamespace})
+	assert.NoErr(t, err)
+	creds, err := provider.credentials(kubeClient, "test-image")
+	assert.NoErr(t, err)
+	assert.Equal(t, creds, registryCredentials{hostname: "https://index.docker.io/v1/", username: "hubuser", password: "hubpassword"}, "credentials")
+}
+
+func TestGetRegistryDetailsUnknownLocation(t *testing.T) {
+	image := "test-image"
+	_, err := getRegistryDetails(&k8s.FakeSecretsNamespacer{}, &image, registryOptions{location: "artifactory"})
+	assert.True(t, err != nil, "no error returned for an unknown registry location")
+}
+
+func TestRegistryHost(t *testing.T) {
+	assert.Equal(t, registryHost("https://index.docker.io/v1/"), "index.docker.io", "registry host")
+	assert.Equal(t, registryHost("Quay.io"), "quay.io", "registry host")
+	assert.Equal(t, registryHost("myregistry.azurecr.io:443"), "myregistry.azurecr.io:443", "registry host")
+}
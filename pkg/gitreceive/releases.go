@@ -0,0 +1,73 @@
+package gitreceive
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/deis/builder/pkg/storage"
+	"github.com/deis/pkg/log"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// ReleasesKeyPattern is the template for the location of the latest releases of an app published
+// by the builder, whose builds the cleaner always keeps.
+const ReleasesKeyPattern = "home/%s/releases"
+
+// releasesRecorded is the number of latest releases recorded for every app.
+const releasesRecorded = 10
+
+// Release is a release of an app published by the builder.
+type Release struct {
+	Version int
+	// Sha is the full git sha of the build of the release
+	Sha string
+}
+
+// RecordedReleases returns the latest releases of app, newest first, as recorded by recordRelease,
+// or none if app was never released since releases started being recorded.
+func RecordedReleases(getter storage.ObjectGetter, app string) ([]Release, error) {
+	key := fmt.Sprintf(ReleasesKeyPattern, app)
+	content, err := storage.GetContentWithChecksum(getter, key)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var releases []Release
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" {
+			continue
+		}
+		var release Release
+		if _, err := fmt.Sscanf(line, "v%d %s", &release.Version, &release.Sha); err != nil {
+			return nil, fmt.Errorf("malformed release %q in %s (%s)", line, key, err)
+		}
+		releases = append(releases, release)
+	}
+	return releases, nil
+}
+
+// recordRelease records version, released from the build of sha, a full git sha, as the latest
+// release of app. Only the latest releasesRecorded releases are kept. A corrupted record is
+// started anew.
+func recordRelease(storageDriver storagedriver.StorageDriver, app string, version int, sha string) error {
+	releases, err := RecordedReleases(storageDriver, app)
+	if err != nil {
+		if _, ok := err.(storage.CorruptedObjectError); !ok {
+			return fmt.Errorf("reading the releases of %s (%s)", app, err)
+		}
+		log.Info("Recording the releases of %s anew (%s)", app, err)
+		releases = nil
+	}
+	releases = append([]Release{{Version: version, Sha: sha}}, releases...)
+	if len(releases) > releasesRecorded {
+		releases = releases[:releasesRecorded]
+	}
+	var content []string
+	for _, release := range releases {
+		content = append(content, fmt.Sprintf("v%d %s\n", release.Version, release.Sha))
+	}
+	_, err = storage.PutContentWithChecksum(storageDriver, fmt.Sprintf(ReleasesKeyPattern, app), []byte(strings.Join(content, "")))
+	return err
+}
@@ -0,0 +1,38 @@
+package gitreceive
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+	_ "github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestRecordRelease(t *testing.T) {
+	storageDriver, err := factory.Create("inmemory", nil)
+	assert.NoErr(t, err)
+	releases, err := RecordedReleases(storageDriver, "myapp")
+	assert.NoErr(t, err)
+	assert.Equal(t, len(releases), 0, "number of releases of an app never released")
+
+	for i := 1; i <= releasesRecorded+2; i++ {
+		assert.NoErr(t, recordRelease(storageDriver, "myapp", i, fmt.Sprintf("%040d", i)))
+	}
+	releases, err = RecordedReleases(storageDriver, "myapp")
+	assert.NoErr(t, err)
+	assert.Equal(t, len(releases), releasesRecorded, "number of releases")
+	assert.Equal(t, releases[0], Release{Version: releasesRecorded + 2, Sha: fmt.Sprintf("%040d", releasesRecorded+2)}, "latest release")
+	assert.Equal(t, releases[releasesRecorded-1].Version, 3, "oldest release")
+
+	// a corrupted record is reported, and started anew by the next release
+	key := fmt.Sprintf(ReleasesKeyPattern, "myapp")
+	assert.NoErr(t, storageDriver.PutContent(context.Background(), key, []byte("v1 deadbeef\n")))
+	_, err = RecordedReleases(storageDriver, "myapp")
+	assert.True(t, err != nil, "no error returned for a corrupted record")
+	assert.NoErr(t, recordRelease(storageDriver, "myapp", 20, "c3b4e4ba5d1a0b0c0d0e0f000102030405060708"))
+	releases, err = RecordedReleases(storageDriver, "myapp")
+	assert.NoErr(t, err)
+	assert.Equal(t, releases, []Release{{Version: 20, Sha: "c3b4e4ba5d1a0b0c0d0e0f000102030405060708"}}, "releases recorded anew")
+}
@@ -2,38 +2,101 @@ package gitreceive
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/deis/builder/pkg/git"
 )
 
 const (
 	slugTGZName = "slug.tgz"
-	// CacheKeyPattern is the template for location cache dirs.
+	// CacheKeyPattern is the template for location cache dirs of builds of the default branch.
 	CacheKeyPattern = "home/%s/cache"
-	// GitKeyPattern is the template for storing git key files.
+	// BranchCachesKeyPattern is the template for the location of the caches of the builds of the
+	// other branches, each keyed by its branch name.
+	BranchCachesKeyPattern = "home/%s/caches"
+	// DockerCacheKeyPattern is the template for the location of the layer cache of Dockerfile builds.
+	DockerCacheKeyPattern = "home/%s/docker-cache"
+	// GitKeyPattern is the template for storing git key files. Depending on the object key layout,
+	// builds are keyed by their full or short git sha.
 	GitKeyPattern = "home/%s:git-%s"
+
+	// ObjectKeyLayoutV1 keys builds and tags their images by the first 8 characters of their git
+	// sha, which may collide in large repositories.
+	ObjectKeyLayoutV1 = "v1"
+	// ObjectKeyLayoutV2 keys builds and tags their images by their full git sha.
+	ObjectKeyLayoutV2 = "v2"
+
+	// shortShaLen is the length of the git sha used in object storage keys by ObjectKeyLayoutV1
+	shortShaLen = 8
+
+	// defaultBranch is the branch apps are deployed from, whose builds use CacheKeyPattern
+	defaultBranch = "master"
 )
 
+// branchKeyRegex matches the characters of branch names that can't be part of object storage keys
+var branchKeyRegex = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// branchCacheKey returns the location of the buildpack cache of the builds of appName pushed to
+// refName, so that builds of different branches don't invalidate each other's cache. Characters
+// that can't be part of object storage keys are replaced with "-", so branches only differing by
+// those share a cache.
+func branchCacheKey(appName, refName string) string {
+	branch := strings.TrimPrefix(refName, "refs/heads/")
+	if branch == "" || branch == defaultBranch {
+		return fmt.Sprintf(CacheKeyPattern, appName)
+	}
+	return fmt.Sprintf(BranchCachesKeyPattern, appName) + "/" + branchKeyRegex.ReplaceAllString(branch, "-")
+}
+
+// buildSha returns the git sha builds are keyed and tagged by in the given object key layout.
+func buildSha(layout string, gitSha *git.SHA) (string, error) {
+	switch layout {
+	case ObjectKeyLayoutV1:
+		return gitSha.Short(), nil
+	case ObjectKeyLayoutV2:
+		return gitSha.Full(), nil
+	default:
+		return "", fmt.Errorf("unknown object key layout %q, must be %s or %s", layout, ObjectKeyLayoutV1, ObjectKeyLayoutV2)
+	}
+}
+
+// buildKeyShas returns the git shas a build may be keyed by, in the order they should be looked up:
+// the given sha, then its legacy short form if sha is a full one.
+func buildKeyShas(sha string) []string {
+	if len(sha) > shortShaLen {
+		return []string{sha, sha[:shortShaLen]}
+	}
+	return []string{sha}
+}
+
 // SlugBuilderInfo contains all of the object storage related information needed to pass to a
 // slug builder.
 type SlugBuilderInfo struct {
 	pushKey        string
 	tarKey         string
 	cacheKey       string
+	dockerCacheKey string
+	logKey         string
 	disableCaching bool
 }
 
-// NewSlugBuilderInfo creates and populates a new SlugBuilderInfo based on the given data
-func NewSlugBuilderInfo(appName string, shortSha string, disableCaching bool) *SlugBuilderInfo {
-	basePath := fmt.Sprintf(GitKeyPattern, appName, shortSha)
+// NewSlugBuilderInfo creates and populates a new SlugBuilderInfo based on the given data. sha is
+// the git sha the build is keyed by, as returned by buildSha, and refName the ref it was pushed to.
+func NewSlugBuilderInfo(appName string, sha string, refName string, disableCaching bool) *SlugBuilderInfo {
+	basePath := fmt.Sprintf(GitKeyPattern, appName, sha)
 	tarKey := fmt.Sprintf("%s/tar", basePath)
 	// this is where workflow tells slugrunner to download the slug from, so we have to tell slugbuilder to upload it to here
 	pushKey := fmt.Sprintf("%s/push", basePath)
 
-	cacheKey := fmt.Sprintf(CacheKeyPattern, appName)
+	cacheKey := branchCacheKey(appName, refName)
 
 	return &SlugBuilderInfo{
 		pushKey:        pushKey,
 		tarKey:         tarKey,
 		cacheKey:       cacheKey,
+		dockerCacheKey: fmt.Sprintf(DockerCacheKeyPattern, appName),
+		logKey:         BuildLogKey(appName, sha),
 		disableCaching: disableCaching,
 	}
 }
@@ -48,10 +111,18 @@ func (s SlugBuilderInfo) PushKey() string { return s.pushKey }
 func (s SlugBuilderInfo) TarKey() string { return s.tarKey }
 
 // CacheKey returns the object storage key that the slug builder will use to store the cache in
-// it's application specific and persisted between deploys (doesn't contain git-sha)
+// it's application and branch specific and persisted between deploys (doesn't contain git-sha)
 func (s SlugBuilderInfo) CacheKey() string { return s.cacheKey }
 
-// DisableCaching dictates whether or not the slugbuilder should persist the buildpack cache.
+// DockerCacheKey returns the object storage key that Dockerfile builds use to store their layer
+// cache in. Like CacheKey, it's application specific and persisted between deploys.
+func (s SlugBuilderInfo) DockerCacheKey() string { return s.dockerCacheKey }
+
+// LogKey returns the object storage key that the compressed output of the build is stored in.
+func (s SlugBuilderInfo) LogKey() string { return s.logKey }
+
+// DisableCaching dictates whether or not the slugbuilder should persist the buildpack cache, and
+// Dockerfile builds their layer cache.
 func (s SlugBuilderInfo) DisableCaching() bool { return s.disableCaching }
 
 // AbsoluteSlugObjectKey returns the PushKey plus the final filename of the slug.
@@ -4,14 +4,40 @@ import (
 	"testing"
 
 	"github.com/arschles/assert"
+	"github.com/deis/builder/pkg/git"
 )
 
 func TestSlugBuilderInfo(t *testing.T) {
-	sbi := NewSlugBuilderInfo("myapp", "c3b4e4ba", false)
+	sbi := NewSlugBuilderInfo("myapp", "c3b4e4ba", "refs/heads/master", false)
 	assert.Equal(t, "home/myapp:git-c3b4e4ba/push", sbi.PushKey(), "key")
 	assert.Equal(t, "home/myapp:git-c3b4e4ba/tar", sbi.TarKey(), "key")
 	assert.Equal(t, "home/myapp/cache", sbi.CacheKey(), "key")
+	assert.Equal(t, "home/myapp/docker-cache", sbi.DockerCacheKey(), "key")
+	assert.Equal(t, "home/myapp:git-c3b4e4ba/log.gz", sbi.LogKey(), "key")
 	assert.Equal(t, "home/myapp:git-c3b4e4ba/push/slug.tgz", sbi.AbsoluteSlugObjectKey(), "key")
 	assert.Equal(t, "home/myapp:git-c3b4e4ba/push/Procfile", sbi.AbsoluteProcfileKey(), "key")
 	assert.Equal(t, false, sbi.DisableCaching(), "key")
+
+	sbi = NewSlugBuilderInfo("myapp", "c3b4e4ba", "refs/heads/feature/login", false)
+	assert.Equal(t, "home/myapp/caches/feature-login", sbi.CacheKey(), "branch cache key")
+	assert.Equal(t, "home/myapp/docker-cache", sbi.DockerCacheKey(), "branch docker cache key")
+}
+
+func TestBuildSha(t *testing.T) {
+	gitSha, err := git.NewSha("c3b4e4ba5d1a0b0c0d0e0f000102030405060708")
+	assert.NoErr(t, err)
+	sha, err := buildSha(ObjectKeyLayoutV1, gitSha)
+	assert.NoErr(t, err)
+	assert.Equal(t, sha, "c3b4e4ba", "v1 sha")
+	sha, err = buildSha(ObjectKeyLayoutV2, gitSha)
+	assert.NoErr(t, err)
+	assert.Equal(t, sha, "c3b4e4ba5d1a0b0c0d0e0f000102030405060708", "v2 sha")
+	_, err = buildSha("v3", gitSha)
+	assert.True(t, err != nil, "no error returned for an unknown layout")
+}
+
+func TestBuildKeyShas(t *testing.T) {
+	full := "c3b4e4ba5d1a0b0c0d0e0f000102030405060708"
+	assert.Equal(t, buildKeyShas(full), []string{full, "c3b4e4ba"}, "full sha")
+	assert.Equal(t, buildKeyShas("c3b4e4ba"), []string{"c3b4e4ba"}, "short sha")
 }
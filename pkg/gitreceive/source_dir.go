@@ -0,0 +1,151 @@
+package gitreceive
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/deis/builder/pkg/storage"
+	"github.com/deis/pkg/log"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// LastBuildKeyPattern is the template for the location of the full git sha of the last successful
+// build of an app, which pushes are compared with to skip builds that don't touch its build paths.
+const LastBuildKeyPattern = "home/%s/last-build"
+
+// App config keys for apps living in a subdirectory of a monorepo.
+const (
+	// appSourceDirKey names the subdirectory of the repo holding the source tree to build
+	appSourceDirKey = "DEIS_SOURCE_DIR"
+	// appBuildPathsKey lists, comma separated, more paths whose changes trigger a build, on top of
+	// the source directory
+	appBuildPathsKey = "DEIS_BUILD_PATHS"
+	// appDockerfilePathKey is the path of the Dockerfile, relative to the source directory
+	appDockerfilePathKey = "DEIS_DOCKERFILE_PATH"
+
+	defaultDockerfile = "Dockerfile"
+)
+
+// cleanRepoPath validates a path relative to the root of the repo and returns it in canonical
+// form, or "" for the root itself.
+func cleanRepoPath(key, p string) (string, error) {
+	cleaned := path.Clean(strings.TrimSpace(p))
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%s must be a path inside the repository, got %q", key, p)
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
+
+// getSourceDir returns the subdirectory of the repo to build, or "" to build the whole repo.
+func getSourceDir(appValues map[string]interface{}) (string, error) {
+	return cleanRepoPath(appSourceDirKey, appValueOrDefault(appValues, appSourceDirKey, ""))
+}
+
+// getDockerfilePath returns the path of the Dockerfile relative to the source directory.
+func getDockerfilePath(appValues map[string]interface{}) (string, error) {
+	dockerfile, err := cleanRepoPath(appDockerfilePathKey, appValueOrDefault(appValues, appDockerfilePathKey, defaultDockerfile))
+	if err != nil {
+		return "", err
+	}
+	if dockerfile == "" {
+		return "", fmt.Errorf("%s must name a file", appDockerfilePathKey)
+	}
+	return dockerfile, nil
+}
+
+// getBuildPaths returns the paths whose changes trigger a build of the app, or nil if any change
+// does.
+func getBuildPaths(appValues map[string]interface{}, sourceDir string) ([]string, error) {
+	if sourceDir == "" {
+		return nil, nil
+	}
+	paths := []string{sourceDir}
+	extra := appValueOrDefault(appValues, appBuildPathsKey, "")
+	if extra == "" {
+		return paths, nil
+	}
+	for _, p := range strings.Split(extra, ",") {
+		cleaned, err := cleanRepoPath(appBuildPathsKey, p)
+		if err != nil {
+			return nil, err
+		}
+		if cleaned == "" {
+			// the root of the repo matches every change
+			return nil, nil
+		}
+		paths = append(paths, cleaned)
+	}
+	return paths, nil
+}
+
+// changedFiles returns the files of the repo in repoDir changed between oldRev and newRev.
+func changedFiles(repoDir, oldRev, newRev string) ([]string, error) {
+	cmd := repoCmd(repoDir, "git", "diff", "--name-only", oldRev, newRev)
+	out := new(bytes.Buffer)
+	cmd.Stdout = out
+	if err := run(cmd); err != nil {
+		return nil, fmt.Errorf("running %s (%s)", strings.Join(cmd.Args, " "), err)
+	}
+	var files []string
+	for _, file := range strings.Split(out.String(), "\n") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// touchesPaths returns true if any of files is one of paths or is inside one of them.
+func touchesPaths(files, paths []string) bool {
+	for _, file := range files {
+		for _, p := range paths {
+			if file == p || strings.HasPrefix(file, p+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lastBuiltSha returns the full git sha of the last successful build of app, as recorded by
+// recordLastBuiltSha, or "" if app was never built since builds started being recorded.
+func lastBuiltSha(getter storage.ObjectGetter, app string) (string, error) {
+	key := fmt.Sprintf(LastBuildKeyPattern, app)
+	sha, err := storage.GetContentWithChecksum(getter, key)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return "", nil
+		}
+		return "", fmt.Errorf("reading %s (%s)", key, err)
+	}
+	return strings.TrimSpace(string(sha)), nil
+}
+
+// recordLastBuiltSha records sha, a full git sha, as the one of the last successful build of app.
+func recordLastBuiltSha(putter storage.ObjectPutter, app, sha string) error {
+	_, err := storage.PutContentWithChecksum(putter, fmt.Sprintf(LastBuildKeyPattern, app), []byte(sha))
+	return err
+}
+
+// pushTouchesPaths returns true if any of paths changed between lastSha, the last revision that
+// was built, and newRev. Apps never built, whose last build isn't in the repo anymore, or without
+// filter, are always built.
+func pushTouchesPaths(repoDir, lastSha, newRev string, paths []string) (bool, error) {
+	if len(paths) == 0 || lastSha == "" {
+		return true, nil
+	}
+	if err := run(repoCmd(repoDir, "git", "cat-file", "-e", lastSha+"^{commit}")); err != nil {
+		log.Debug("last built revision %s is not in the repository (%s)", lastSha, err)
+		return true, nil
+	}
+	files, err := changedFiles(repoDir, lastSha, newRev)
+	if err != nil {
+		return false, err
+	}
+	return touchesPaths(files, paths), nil
+}
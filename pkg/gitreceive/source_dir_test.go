@@ -0,0 +1,152 @@
+package gitreceive
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+	_ "github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestGetSourceDir(t *testing.T) {
+	cases := map[string]string{
+		"":               "",
+		".":              "",
+		"services/api":   "services/api",
+		"services/api/":  "services/api",
+		" services/api ": "services/api",
+		"./web/../api":   "api",
+	}
+	for value, expected := range cases {
+		dir, err := getSourceDir(map[string]interface{}{appSourceDirKey: value})
+		assert.NoErr(t, err)
+		assert.Equal(t, dir, expected, value)
+	}
+	for _, value := range []string{"/services/api", "..", "../other", "api/../../other"} {
+		_, err := getSourceDir(map[string]interface{}{appSourceDirKey: value})
+		assert.True(t, err != nil, "no error for source dir %s", value)
+	}
+}
+
+func TestGetDockerfilePath(t *testing.T) {
+	dockerfile, err := getDockerfilePath(nil)
+	assert.NoErr(t, err)
+	assert.Equal(t, dockerfile, defaultDockerfile, "default Dockerfile path")
+
+	dockerfile, err = getDockerfilePath(map[string]interface{}{appDockerfilePathKey: "docker/Dockerfile.prod"})
+	assert.NoErr(t, err)
+	assert.Equal(t, dockerfile, "docker/Dockerfile.prod", "Dockerfile path")
+
+	_, err = getDockerfilePath(map[string]interface{}{appDockerfilePathKey: "."})
+	assert.True(t, err != nil, "no error for a Dockerfile path naming a directory")
+}
+
+func TestGetBuildPaths(t *testing.T) {
+	paths, err := getBuildPaths(map[string]interface{}{appBuildPathsKey: "libs"}, "")
+	assert.NoErr(t, err)
+	assert.Equal(t, len(paths), 0, "number of build paths without source dir")
+
+	paths, err = getBuildPaths(nil, "services/api")
+	assert.NoErr(t, err)
+	assert.Equal(t, paths, []string{"services/api"}, "build paths")
+
+	paths, err = getBuildPaths(map[string]interface{}{appBuildPathsKey: "libs/common, proto"}, "services/api")
+	assert.NoErr(t, err)
+	assert.Equal(t, paths, []string{"services/api", "libs/common", "proto"}, "build paths")
+
+	paths, err = getBuildPaths(map[string]interface{}{appBuildPathsKey: "libs,."}, "services/api")
+	assert.NoErr(t, err)
+	assert.Equal(t, len(paths), 0, "number of build paths including the repo root")
+}
+
+func TestTouchesPaths(t *testing.T) {
+	paths := []string{"services/api", "go.mod"}
+	assert.True(t, touchesPaths([]string{"README.md", "services/api/main.go"}, paths), "change in the source dir")
+	assert.True(t, touchesPaths([]string{"go.mod"}, paths), "change of a build path file")
+	assert.False(t, touchesPaths([]string{"services/api2/main.go", "services/web/main.go"}, paths), "changes outside build paths")
+	assert.False(t, touchesPaths(nil, paths), "no changes")
+}
+
+// initTestRepo creates a git repo in repoDir. It returns a function committing a file, whose
+// content is its name, and returning the sha of the commit.
+func initTestRepo(t *testing.T, repoDir string) func(file string) string {
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repoDir
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("error running git %s (%s)", strings.Join(args, " "), err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init")
+	return func(file string) string {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(repoDir, file)), 0755); err != nil {
+			t.Fatalf("error creating the directory of %s (%s)", file, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(repoDir, file), []byte(file), 0644); err != nil {
+			t.Fatalf("error writing %s (%s)", file, err)
+		}
+		git("add", file)
+		git("commit", "-m", file)
+		return git("rev-parse", "HEAD")
+	}
+}
+
+func TestLastBuiltSha(t *testing.T) {
+	storageDriver, err := factory.Create("inmemory", nil)
+	assert.NoErr(t, err)
+	sha, err := lastBuiltSha(storageDriver, "myapp")
+	assert.NoErr(t, err)
+	assert.Equal(t, sha, "", "sha of an app never built")
+
+	assert.NoErr(t, recordLastBuiltSha(storageDriver, "myapp", "c3b4e4ba5d1a0b0c0d0e0f000102030405060708"))
+	sha, err = lastBuiltSha(storageDriver, "myapp")
+	assert.NoErr(t, err)
+	assert.Equal(t, sha, "c3b4e4ba5d1a0b0c0d0e0f000102030405060708", "last built sha")
+}
+
+func TestPushTouchesPaths(t *testing.T) {
+	repoDir, err := ioutil.TempDir("", "repo")
+	if err != nil {
+		t.Fatalf("error creating temp directory (%s)", err)
+	}
+	defer os.RemoveAll(repoDir)
+
+	commit := initTestRepo(t, repoDir)
+	first := commit("services/api/main.go")
+	second := commit("services/web/main.go")
+	third := commit("services/api/handler.go")
+
+	paths := []string{"services/api"}
+	touched, err := pushTouchesPaths(repoDir, first, second, paths)
+	assert.NoErr(t, err)
+	assert.False(t, touched, "push outside of the source dir")
+
+	touched, err = pushTouchesPaths(repoDir, second, third, paths)
+	assert.NoErr(t, err)
+	assert.True(t, touched, "push in the source dir")
+
+	// the push of third failed to build, so the next push builds it even outside of the source dir
+	fourth := commit("services/web/handler.go")
+	touched, err = pushTouchesPaths(repoDir, second, fourth, paths)
+	assert.NoErr(t, err)
+	assert.True(t, touched, "push since a failed build in the source dir")
+
+	touched, err = pushTouchesPaths(repoDir, "", second, paths)
+	assert.NoErr(t, err)
+	assert.True(t, touched, "push of an app never built")
+
+	touched, err = pushTouchesPaths(repoDir, "0123456789abcdef0123456789abcdef01234567", second, paths)
+	assert.NoErr(t, err)
+	assert.True(t, touched, "push of an app whose last build is not in the repo")
+
+	touched, err = pushTouchesPaths(repoDir, first, second, nil)
+	assert.NoErr(t, err)
+	assert.True(t, touched, "push without build paths")
+}
@@ -0,0 +1,83 @@
+package gitreceive
+
+import (
+	"strings"
+
+	"github.com/deis/builder/pkg/storage"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	storageVolumeName        = "builder-storage"
+	storageRootDir           = "BUILDER_STORAGE_ROOT_DIRECTORY"
+	encryptionKeysVolumeName = "encryption-keys"
+	encryptionKeysDir        = "BUILDER_ENCRYPTION_KEYS_DIR"
+)
+
+// mountFilesystemStorage gives pod access to the objects of the filesystem storage type in place
+// of the object storage credentials: the persistent volume claim holding them is mounted at the
+// same path as in the builder, passed to the builder container in BUILDER_STORAGE_ROOT_DIRECTORY.
+func mountFilesystemStorage(pod *api.Pod, claimName, rootDirectory string) {
+	volumes := pod.Spec.Volumes[:0]
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name != objectStore {
+			volumes = append(volumes, volume)
+		}
+	}
+	pod.Spec.Volumes = append(volumes, api.Volume{
+		Name: storageVolumeName,
+		VolumeSource: api.VolumeSource{
+			PersistentVolumeClaim: &api.PersistentVolumeClaimVolumeSource{
+				ClaimName: claimName,
+			},
+		},
+	})
+
+	container := &pod.Spec.Containers[0]
+	mounts := container.VolumeMounts[:0]
+	for _, mount := range container.VolumeMounts {
+		if mount.Name != objectStore {
+			mounts = append(mounts, mount)
+		}
+	}
+	container.VolumeMounts = append(mounts, api.VolumeMount{
+		Name:      storageVolumeName,
+		MountPath: rootDirectory,
+	})
+	addEnvToPod(*pod, storageRootDir, rootDirectory)
+}
+
+// mountEncryptionKeys gives pod the keys source tarballs are encrypted with: the secret holding
+// them is mounted in the builder container at BUILDER_ENCRYPTION_KEYS_DIR, and
+// BUILDER_ENCRYPTION_KEY_ID names the key the tarball was encrypted with. Builder containers must
+// decrypt the tarball they download, which only the ones encryptionSupported accepts do.
+func mountEncryptionKeys(pod *api.Pod, secretName, keyID string) {
+	pod.Spec.Volumes = append(pod.Spec.Volumes, api.Volume{
+		Name: encryptionKeysVolumeName,
+		VolumeSource: api.VolumeSource{
+			Secret: &api.SecretVolumeSource{
+				SecretName: secretName,
+			},
+		},
+	})
+	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, api.VolumeMount{
+		Name:      encryptionKeysVolumeName,
+		MountPath: storage.EncryptionKeysLocation,
+		ReadOnly:  true,
+	})
+	addEnvToPod(*pod, encryptionKeysDir, storage.EncryptionKeysLocation)
+	addEnvToPod(*pod, storage.EncryptionKeyIDEnvVar, keyID)
+}
+
+// encryptionSupported returns true if the builder container of pod is listed, without its "deis-"
+// prefix, in BUILDER_ENCRYPTION_PODS: the builders known to decrypt the tarball they download with
+// the keys mountEncryptionKeys gives them.
+func encryptionSupported(conf *Config, pod *api.Pod) bool {
+	name := strings.TrimPrefix(pod.Spec.Containers[0].Name, "deis-")
+	for _, supported := range strings.Split(conf.EncryptionPods, ",") {
+		if strings.TrimSpace(supported) == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package gitreceive
+
+import (
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/deis/builder/pkg/storage"
+	"k8s.io/kubernetes/pkg/api"
+)
+
+func TestMountFilesystemStorage(t *testing.T) {
+	template := &api.Pod{
+		Spec: api.PodSpec{
+			Volumes: []api.Volume{{Name: "sidecar-config"}},
+		},
+	}
+	pod, err := buildPod(false, "test", "deis", api.PullAlways, nil, nil, template)
+	assert.NoErr(t, err)
+	mountFilesystemStorage(&pod, "deis-builder-storage", "/var/lib/deis/builder/storage")
+
+	assert.Equal(t, len(pod.Spec.Volumes), 2, "number of volumes")
+	assert.Equal(t, pod.Spec.Volumes[0].Name, "sidecar-config", "template volume")
+	assert.Equal(t, pod.Spec.Volumes[1].Name, storageVolumeName, "storage volume")
+	assert.Equal(t, pod.Spec.Volumes[1].PersistentVolumeClaim.ClaimName, "deis-builder-storage", "claim name")
+
+	container := pod.Spec.Containers[0]
+	assert.Equal(t, container.VolumeMounts, []api.VolumeMount{{Name: storageVolumeName, MountPath: "/var/lib/deis/builder/storage"}}, "volume mounts")
+	assert.Equal(t, container.Env, []api.EnvVar{{Name: storageRootDir, Value: "/var/lib/deis/builder/storage"}}, "env")
+}
+
+func TestMountEncryptionKeys(t *testing.T) {
+	pod, err := buildPod(false, "test", "deis", api.PullAlways, nil, nil, nil)
+	assert.NoErr(t, err)
+	mountEncryptionKeys(&pod, "builder-encryption-keys", "key2")
+
+	volume := pod.Spec.Volumes[len(pod.Spec.Volumes)-1]
+	assert.Equal(t, volume.Secret.SecretName, "builder-encryption-keys", "secret name")
+	mount := pod.Spec.Containers[0].VolumeMounts[len(pod.Spec.Containers[0].VolumeMounts)-1]
+	assert.Equal(t, mount, api.VolumeMount{Name: encryptionKeysVolumeName, MountPath: storage.EncryptionKeysLocation, ReadOnly: true}, "volume mount")
+	assert.Equal(t, pod.Spec.Containers[0].Env, []api.EnvVar{
+		{Name: encryptionKeysDir, Value: storage.EncryptionKeysLocation},
+		{Name: storage.EncryptionKeyIDEnvVar, Value: "key2"},
+	}, "env")
+}
+
+func TestEncryptionSupported(t *testing.T) {
+	pod, err := buildPod(false, "test", "deis", api.PullAlways, nil, nil, nil)
+	assert.NoErr(t, err)
+	pod.Spec.Containers[0].Name = slugBuilderName
+	assert.False(t, encryptionSupported(&Config{}, &pod), "slugbuilder supported by default")
+	assert.True(t, encryptionSupported(&Config{EncryptionPods: "cnbbuilder, slugbuilder"}, &pod), "slugbuilder not supported")
+	pod.Spec.Containers[0].Name = dockerBuilderName
+	assert.False(t, encryptionSupported(&Config{EncryptionPods: "cnbbuilder, slugbuilder"}, &pod), "dockerbuilder supported")
+}
@@ -0,0 +1,43 @@
+package healthsrv
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/deis/builder/pkg/gitreceive"
+	"github.com/deis/builder/pkg/storage"
+)
+
+const (
+	buildLogPathPrefix = "/builds/"
+)
+
+// buildLogHandler serves the persisted log of a build at /builds/<app>/<git sha>/log. Requests
+// need to be authenticated with the builder key, in the same way the controller hooks are.
+func buildLogHandler(getter storage.ObjectGetter, builderKey string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token "+builderKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, buildLogPathPrefix), "/")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] != "log" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		buildLog, err := gitreceive.GetBuildLog(getter, parts[0], parts[1])
+		if _, ok := err.(storage.CorruptedObjectError); ok {
+			log.Printf("Error getting build log for %s (%s)", r.URL.Path, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if err != nil {
+			log.Printf("Error getting build log for %s (%s)", r.URL.Path, err)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buildLog)
+	})
+}
@@ -0,0 +1,65 @@
+package healthsrv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/deis/builder/pkg/storage"
+	"github.com/docker/distribution/context"
+)
+
+func TestBuildLogUnauthorized(t *testing.T) {
+	getter := &storage.FakeObjectGetter{}
+	h := buildLogHandler(getter, "builderkey")
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("GET", "/builds/myapp/c3b4e4ba/log", bytes.NewBuffer(nil))
+	assert.NoErr(t, err)
+	h.ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusUnauthorized, "response code")
+	assert.Equal(t, len(getter.Calls), 0, "number of GetContent calls")
+}
+
+func TestBuildLogNotFound(t *testing.T) {
+	getter := &storage.FakeObjectGetter{
+		Fn: func(context.Context, string) ([]byte, error) {
+			return nil, errTest
+		},
+	}
+	h := buildLogHandler(getter, "builderkey")
+	for _, path := range []string{"/builds/myapp/c3b4e4ba/log", "/builds/myapp/log", "/builds/myapp/c3b4e4ba/tar"} {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", path, bytes.NewBuffer(nil))
+		assert.NoErr(t, err)
+		r.Header.Set("Authorization", "token builderkey")
+		h.ServeHTTP(w, r)
+		assert.Equal(t, w.Code, http.StatusNotFound, "response code")
+	}
+}
+
+func TestBuildLogSuccess(t *testing.T) {
+	compressed := new(bytes.Buffer)
+	gzw := gzip.NewWriter(compressed)
+	gzw.Write([]byte("2016-01-02T15:04:05Z Build complete.\n"))
+	gzw.Close()
+	getter := &storage.FakeObjectGetter{
+		Fn: func(ctx context.Context, path string) ([]byte, error) {
+			if path == "home/myapp:git-c3b4e4ba/log.gz.sha256" {
+				return []byte(storage.Checksum(compressed.Bytes())), nil
+			}
+			return compressed.Bytes(), nil
+		},
+	}
+	h := buildLogHandler(getter, "builderkey")
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("GET", "/builds/myapp/c3b4e4ba/log", bytes.NewBuffer(nil))
+	assert.NoErr(t, err)
+	r.Header.Set("Authorization", "token builderkey")
+	h.ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusOK, "response code")
+	assert.Equal(t, w.Body.String(), "2016-01-02T15:04:05Z Build complete.\n", "response body")
+	assert.Equal(t, getter.Calls[0].Path, "home/myapp:git-c3b4e4ba/log.gz", "object key")
+}
@@ -0,0 +1,40 @@
+package healthsrv
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/deis/builder/pkg/gitreceive"
+	"github.com/deis/builder/pkg/storage"
+)
+
+const (
+	cachePathPrefix = "/caches/"
+)
+
+// cacheHandler purges the build caches of an app on DELETE /caches/<app>. Requests need to be
+// authenticated with the builder key, in the same way the controller hooks are.
+func cacheHandler(deleter storage.ObjectDeleter, builderKey string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token "+builderKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != "DELETE" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		appName := strings.TrimPrefix(r.URL.Path, cachePathPrefix)
+		if appName == "" || strings.Contains(appName, "/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := gitreceive.PurgeCache(deleter, appName); err != nil {
+			log.Printf("Error purging the build cache of %s (%s)", appName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,67 @@
+package healthsrv
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/deis/builder/pkg/storage"
+	"github.com/docker/distribution/context"
+)
+
+func TestCacheUnauthorized(t *testing.T) {
+	deleter := &storage.FakeObjectDeleter{}
+	h := cacheHandler(deleter, "builderkey")
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("DELETE", "/caches/myapp", bytes.NewBuffer(nil))
+	assert.NoErr(t, err)
+	h.ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusUnauthorized, "response code")
+	assert.Equal(t, len(deleter.Calls), 0, "number of Delete calls")
+}
+
+func TestCacheMethodNotAllowed(t *testing.T) {
+	deleter := &storage.FakeObjectDeleter{}
+	h := cacheHandler(deleter, "builderkey")
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("GET", "/caches/myapp", bytes.NewBuffer(nil))
+	assert.NoErr(t, err)
+	r.Header.Set("Authorization", "token builderkey")
+	h.ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusMethodNotAllowed, "response code")
+	assert.Equal(t, len(deleter.Calls), 0, "number of Delete calls")
+}
+
+func TestCachePurge(t *testing.T) {
+	deleter := &storage.FakeObjectDeleter{
+		Fn: func(context.Context, string) error {
+			return nil
+		},
+	}
+	h := cacheHandler(deleter, "builderkey")
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("DELETE", "/caches/myapp", bytes.NewBuffer(nil))
+	assert.NoErr(t, err)
+	r.Header.Set("Authorization", "token builderkey")
+	h.ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusNoContent, "response code")
+	assert.Equal(t, len(deleter.Calls), 5, "number of Delete calls")
+	assert.Equal(t, deleter.Calls[0].Path, "home/myapp/cache", "object key")
+}
+
+func TestCachePurgeError(t *testing.T) {
+	deleter := &storage.FakeObjectDeleter{
+		Fn: func(context.Context, string) error {
+			return errTest
+		},
+	}
+	h := cacheHandler(deleter, "builderkey")
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("DELETE", "/caches/myapp", bytes.NewBuffer(nil))
+	assert.NoErr(t, err)
+	r.Header.Set("Authorization", "token builderkey")
+	h.ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusInternalServerError, "response code")
+}
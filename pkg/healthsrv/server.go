@@ -4,13 +4,15 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/deis/builder/pkg/conf"
 	"github.com/deis/builder/pkg/controller"
 	"github.com/deis/builder/pkg/sshd"
+	"github.com/deis/builder/pkg/storage"
 )
 
 // Start starts the healthcheck server on :$port and blocks. It only returns if the server fails,
 // with the indicative error.
-func Start(cnf *sshd.Config, nsLister NamespaceLister, bLister BucketLister, sshServerCircuit *sshd.Circuit) error {
+func Start(cnf *sshd.Config, nsLister NamespaceLister, bLister BucketLister, objGetter storage.ObjectGetter, objDeleter storage.ObjectDeleter, sshServerCircuit *sshd.Circuit) error {
 	mux := http.NewServeMux()
 	client, err := controller.New(cnf.ControllerHost, cnf.ControllerPort)
 	if err != nil {
@@ -19,6 +21,13 @@ func Start(cnf *sshd.Config, nsLister NamespaceLister, bLister BucketLister, ssh
 	mux.Handle("/healthz", healthZHandler(bLister, sshServerCircuit))
 	mux.Handle("/readiness", readinessHandler(client, nsLister))
 
+	builderKey, err := conf.GetBuilderKey()
+	if err != nil {
+		return err
+	}
+	mux.Handle(buildLogPathPrefix, buildLogHandler(objGetter, builderKey))
+	mux.Handle(cachePathPrefix, cacheHandler(objDeleter, builderKey))
+
 	hostStr := fmt.Sprintf(":%d", cnf.HealthSrvPort)
 	return http.ListenAndServe(hostStr, mux)
 }
@@ -17,6 +17,12 @@ type Config struct {
 	SlugBuilderImagePullPolicy   string `envconfig:"SLUG_BUILDER_IMAGE_PULL_POLICY" default:"Always"`
 	DockerBuilderImagePullPolicy string `envconfig:"DOCKER_BUILDER_IMAGE_PULL_POLICY" default:"Always"`
 	LockTimeout                  int    `envconfig:"GIT_LOCK_TIMEOUT" default:"10"`
+	BuildLogRetentionDays        int    `envconfig:"BUILD_LOG_RETENTION_DAYS" default:"30"`
+	BuildpackCacheMaxAgeDays     int    `envconfig:"BUILDPACK_CACHE_MAX_AGE_DAYS" default:"0"`
+	BuildpackCacheMaxSizeMB      int64  `envconfig:"BUILDPACK_CACHE_MAX_SIZE_MB" default:"0"`
+	BuildRetentionKeepLast       int    `envconfig:"BUILD_RETENTION_KEEP_LAST" default:"0"`
+	BuildRetentionMaxAgeDays     int    `envconfig:"BUILD_RETENTION_MAX_AGE_DAYS" default:"0"`
+	BuildRetentionRegistry       string `envconfig:"BUILD_RETENTION_REGISTRY" default:""`
 }
 
 // CleanerPollSleepDuration returns c.CleanerPollSleepDurationSec as a time.Duration.
@@ -28,3 +34,23 @@ func (c Config) CleanerPollSleepDuration() time.Duration {
 func (c Config) GitLockTimeout() time.Duration {
 	return time.Duration(c.LockTimeout) * time.Minute
 }
+
+// BuildLogRetention returns c.BuildLogRetentionDays as a time.Duration.
+func (c Config) BuildLogRetention() time.Duration {
+	return time.Duration(c.BuildLogRetentionDays) * 24 * time.Hour
+}
+
+// BuildpackCacheMaxAge returns c.BuildpackCacheMaxAgeDays as a time.Duration.
+func (c Config) BuildpackCacheMaxAge() time.Duration {
+	return time.Duration(c.BuildpackCacheMaxAgeDays) * 24 * time.Hour
+}
+
+// BuildpackCacheMaxSize returns c.BuildpackCacheMaxSizeMB in bytes.
+func (c Config) BuildpackCacheMaxSize() int64 {
+	return c.BuildpackCacheMaxSizeMB * 1024 * 1024
+}
+
+// BuildRetentionMaxAge returns c.BuildRetentionMaxAgeDays as a time.Duration.
+func (c Config) BuildRetentionMaxAge() time.Duration {
+	return time.Duration(c.BuildRetentionMaxAgeDays) * 24 * time.Hour
+}
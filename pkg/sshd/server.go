@@ -13,6 +13,8 @@ import (
 
 	"github.com/deis/builder/pkg/controller"
 	"github.com/deis/builder/pkg/git"
+	"github.com/deis/builder/pkg/gitreceive"
+	"github.com/deis/builder/pkg/storage"
 	"github.com/deis/controller-sdk-go/hooks"
 	"github.com/deis/pkg/log"
 	"golang.org/x/crypto/ssh"
@@ -32,6 +34,8 @@ const (
 var errBuildAppPerm = errors.New("user has no permission to build the app")
 var errDirPerm = errors.New("Cannot change directory in file name.")
 var errDirCreatePerm = errors.New("Empty repo name.")
+var errBuildLogsArgs = errors.New("usage: logs <app> <git sha>")
+var errPurgeCacheArgs = errors.New("usage: purge-cache <app>")
 
 // AuthKey authenticates based on a public key.
 func AuthKey(key ssh.PublicKey, cnf *Config) (*ssh.Permissions, error) {
@@ -106,7 +110,9 @@ func Serve(
 	serverCircuit *Circuit,
 	gitHomeDir string,
 	concurrentPushLock RepositoryLock,
-	addr, receivetype string) error {
+	addr, receivetype string,
+	objGetter storage.ObjectGetter,
+	objDeleter storage.ObjectDeleter) error {
 
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -117,6 +123,8 @@ func Serve(
 		gitHome:     gitHomeDir,
 		pushLock:    concurrentPushLock,
 		receivetype: receivetype,
+		objGetter:   objGetter,
+		objDeleter:  objDeleter,
 	}
 
 	log.Info("Listening on %s", addr)
@@ -131,6 +139,8 @@ type server struct {
 	gitHome     string
 	pushLock    RepositoryLock
 	receivetype string
+	objGetter   storage.ObjectGetter
+	objDeleter  storage.ObjectDeleter
 }
 
 // listen handles accepting and managing connections. However, since closer
@@ -206,10 +216,10 @@ func sendExitStatus(status uint32, channel ssh.Channel) error {
 
 // answer handles answering requests and channel requests
 //
-// Currently, an exec must be either "ping", "git-receive-pack" or
-// "git-upload-pack". Anything else will result in a failure response. Right
-// now, we leave the channel open on failure because it is unclear what the
-// correct behavior for a failed exec is.
+// Currently, an exec must be either "ping", "logs", "purge-cache",
+// "git-receive-pack" or "git-upload-pack". Anything else will result in a
+// failure response. Right now, we leave the channel open on failure because it
+// is unclear what the correct behavior for a failed exec is.
 //
 // Support for setting environment variables via `env` has been disabled.
 func (s *server) answer(channel ssh.Channel, requests <-chan *ssh.Request, condata string, sshconn *ssh.ServerConn) error {
@@ -235,6 +245,20 @@ func (s *server) answer(channel ssh.Channel, requests <-chan *ssh.Request, conda
 					log.Info("Error pinging: %s", err)
 				}
 				return err
+			case "logs":
+				if err := s.buildLogs(channel, req, sshconn, parts); err != nil {
+					log.Info("Error fetching build logs: %s", err)
+					channel.Stderr().Write([]byte(err.Error() + "\n"))
+					sendExitStatus(1, channel)
+				}
+				return nil
+			case "purge-cache":
+				if err := s.purgeCache(channel, req, sshconn, parts); err != nil {
+					log.Info("Error purging build cache: %s", err)
+					channel.Stderr().Write([]byte(err.Error() + "\n"))
+					sendExitStatus(1, channel)
+				}
+				return nil
 			case "git-receive-pack", "git-upload-pack":
 				if len(parts) < 2 {
 					log.Info("Expected two-part command.")
@@ -316,6 +340,56 @@ func (s *server) runReceive(
 	}
 }
 
+// buildLogs writes the persisted log of a previous build to channel. It expects parts to be the
+// command followed by "<app> <git sha>", and the user to have permission on the app.
+func (s *server) buildLogs(channel ssh.Channel, req *ssh.Request, sshConn *ssh.ServerConn, parts []string) error {
+	req.Reply(true, nil)
+	if len(parts) < 2 {
+		return errBuildLogsArgs
+	}
+	args := strings.Fields(parts[1])
+	if len(args) != 2 {
+		return errBuildLogsArgs
+	}
+	appName, sha := args[0], args[1]
+	if !hasApp(sshConn.Permissions.Extensions["apps"], appName) {
+		return errBuildAppPerm
+	}
+	buildLog, err := gitreceive.GetBuildLog(s.objGetter, appName, sha)
+	if err != nil {
+		return err
+	}
+	if _, err := channel.Write(buildLog); err != nil {
+		return err
+	}
+	return sendExitStatus(0, channel)
+}
+
+// purgeCache deletes the build caches of an app, so that its next build starts from scratch. It
+// expects parts to be the command followed by "<app>", and the user to have permission on the app.
+func (s *server) purgeCache(channel ssh.Channel, req *ssh.Request, sshConn *ssh.ServerConn, parts []string) error {
+	req.Reply(true, nil)
+	if len(parts) < 2 {
+		return errPurgeCacheArgs
+	}
+	args := strings.Fields(parts[1])
+	if len(args) != 1 {
+		return errPurgeCacheArgs
+	}
+	appName := args[0]
+	if !hasApp(sshConn.Permissions.Extensions["apps"], appName) {
+		return errBuildAppPerm
+	}
+	if err := gitreceive.PurgeCache(s.objDeleter, appName); err != nil {
+		return err
+	}
+	log.Info("Purged the build cache of app %s", appName)
+	if _, err := fmt.Fprintf(channel, "Purged the build cache of %s\n", appName); err != nil {
+		return err
+	}
+	return sendExitStatus(0, channel)
+}
+
 // ExecCmd is an SSH exec request.
 type ExecCmd struct {
 	Value string
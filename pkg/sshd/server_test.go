@@ -246,7 +246,7 @@ func runServer(
 	t *testing.T) {
 
 	go func() {
-		if err := Serve(config, c, gitHome, pushLock, testAddr, "mock"); err != nil {
+		if err := Serve(config, c, gitHome, pushLock, testAddr, "mock", nil, nil); err != nil {
 			t.Fatalf("Failed serving with %s", err)
 		}
 	}()
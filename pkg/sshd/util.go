@@ -3,6 +3,7 @@ package sshd
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"strings"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -26,3 +27,14 @@ func fingerprint(key ssh.PublicKey) string {
 	}
 	return string(fp)
 }
+
+// hasApp returns true if appName is one of the entries in apps, the comma separated list of apps
+// that is stored in the permissions of an authenticated connection.
+func hasApp(apps, appName string) bool {
+	for _, app := range strings.Split(apps, ",") {
+		if strings.TrimSpace(app) == appName {
+			return true
+		}
+	}
+	return false
+}
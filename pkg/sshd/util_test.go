@@ -48,3 +48,19 @@ func TestFingerprint(t *testing.T) {
 		t.Errorf("Expected fingerprint %s to match %s.", fp, testingClientFingerprint)
 	}
 }
+
+func TestHasApp(t *testing.T) {
+	apps := "myapp, otherapp"
+	if !hasApp(apps, "myapp") {
+		t.Errorf("expected myapp to be in %s", apps)
+	}
+	if !hasApp(apps, "otherapp") {
+		t.Errorf("expected otherapp to be in %s", apps)
+	}
+	if hasApp(apps, "app") {
+		t.Errorf("expected app not to be in %s", apps)
+	}
+	if hasApp("", "myapp") {
+		t.Error("expected myapp not to be in an empty app list")
+	}
+}
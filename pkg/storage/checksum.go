@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// ChecksumSuffix is appended to the key of an object to get the key of its checksum, the hex
+// encoded sha256 of its content.
+const ChecksumSuffix = ".sha256"
+
+// CorruptedObjectError is returned when the content of an object doesn't match its checksum.
+type CorruptedObjectError struct {
+	Key      string
+	Expected string
+	Actual   string
+}
+
+// Error is the error interface implementation.
+func (e CorruptedObjectError) Error() string {
+	return fmt.Sprintf("object %s corrupted: expected sha256 %s, got %s", e.Key, e.Expected, e.Actual)
+}
+
+// ChecksumKey returns the key of the checksum of the object at key.
+func ChecksumKey(key string) string {
+	return key + ChecksumSuffix
+}
+
+// Checksum returns the checksum of content, as stored alongside objects.
+func Checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// PutContentWithChecksum writes content to key, then its checksum to ChecksumKey(key). It returns
+// the checksum. When putter can delete objects, as storage drivers do, the previous checksum is
+// deleted first, so that a failed write leaves the object without checksum rather than reported
+// as corrupted.
+func PutContentWithChecksum(putter ObjectPutter, key string, content []byte) (string, error) {
+	sum := Checksum(content)
+	if deleter, ok := putter.(ObjectDeleter); ok {
+		err := deleter.Delete(context.Background(), ChecksumKey(key))
+		if _, ok := err.(storagedriver.PathNotFoundError); err != nil && !ok {
+			return "", fmt.Errorf("deleting the checksum of %s (%s)", key, err)
+		}
+	}
+	if err := putter.PutContent(context.Background(), key, content); err != nil {
+		return "", err
+	}
+	if err := putter.PutContent(context.Background(), ChecksumKey(key), []byte(sum)); err != nil {
+		return "", fmt.Errorf("writing the checksum of %s (%s)", key, err)
+	}
+	return sum, nil
+}
+
+// GetContentWithChecksum reads the object at key and verifies it against its checksum. It returns
+// a CorruptedObjectError if they don't match. Objects without a checksum, written by older
+// builders or by builder pods that don't write one, are returned unverified.
+func GetContentWithChecksum(getter ObjectGetter, key string) ([]byte, error) {
+	content, err := getter.GetContent(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	rawSum, err := getter.GetContent(context.Background(), ChecksumKey(key))
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return content, nil
+		}
+		return nil, fmt.Errorf("reading the checksum of %s (%s)", key, err)
+	}
+	expected := strings.TrimSpace(string(rawSum))
+	if actual := Checksum(content); actual != expected {
+		return nil, CorruptedObjectError{Key: key, Expected: expected, Actual: actual}
+	}
+	return content, nil
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+)
+
+func TestContentWithChecksum(t *testing.T) {
+	driver, err := factory.Create("inmemory", nil)
+	assert.NoErr(t, err)
+	key := "/home/myapp:git-c3b4e4ba/tar"
+
+	sum, err := PutContentWithChecksum(driver, key, []byte("tar"))
+	assert.NoErr(t, err)
+	assert.Equal(t, sum, Checksum([]byte("tar")), "checksum")
+	stored, err := driver.GetContent(context.Background(), key+".sha256")
+	assert.NoErr(t, err)
+	assert.Equal(t, string(stored), sum, "stored checksum")
+
+	content, err := GetContentWithChecksum(driver, key)
+	assert.NoErr(t, err)
+	assert.Equal(t, string(content), "tar", "content")
+
+	assert.NoErr(t, driver.PutContent(context.Background(), key, []byte("rat")))
+	_, err = GetContentWithChecksum(driver, key)
+	assert.Err(t, err, CorruptedObjectError{Key: key, Expected: sum, Actual: Checksum([]byte("rat"))})
+
+	// objects written without a checksum are not verified
+	assert.NoErr(t, driver.Delete(context.Background(), ChecksumKey(key)))
+	content, err = GetContentWithChecksum(driver, key)
+	assert.NoErr(t, err)
+	assert.Equal(t, string(content), "rat", "content")
+}
+
+// failingChecksumDriver fails to write checksums.
+type failingChecksumDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d failingChecksumDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	if strings.HasSuffix(path, ChecksumSuffix) {
+		return errors.New("test error")
+	}
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+func TestPutContentWithChecksumFailure(t *testing.T) {
+	driver, err := factory.Create("inmemory", nil)
+	assert.NoErr(t, err)
+	key := "/home/myapp/cache.json"
+	_, err = PutContentWithChecksum(driver, key, []byte("{}"))
+	assert.NoErr(t, err)
+
+	_, err = PutContentWithChecksum(failingChecksumDriver{driver}, key, []byte(`{"size": 42}`))
+	assert.True(t, err != nil, "no error returned when the checksum write failed")
+	// the object is left without checksum rather than reported as corrupted
+	content, err := GetContentWithChecksum(driver, key)
+	assert.NoErr(t, err)
+	assert.Equal(t, string(content), `{"size": 42}`, "content")
+}
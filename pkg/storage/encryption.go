@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+const (
+	// EncryptionKeysLocation is the path of the secret holding the keys objects are encrypted with.
+	// Each file in it is a key, named by its ID, holding 32 bytes, raw or base64 encoded.
+	EncryptionKeysLocation = "/var/run/secrets/deis/builder/encryption"
+	// EncryptionKeyIDEnvVar names the key new objects are encrypted with. Objects are not
+	// encrypted if it's empty.
+	EncryptionKeyIDEnvVar = "BUILDER_ENCRYPTION_KEY_ID"
+
+	encryptionKeySize = 32
+	// encryptedMagic starts every encrypted object. It's followed by an encryptionHeader in JSON on
+	// a single line, then by the content sealed with the data key of the object.
+	encryptedMagic = "deis-encrypted-v1\n"
+)
+
+// encryptedSuffixes are the suffixes of the keys of the objects encrypted at rest: source
+// tarballs, the only objects the builder itself writes that hold code. Slugs and build caches are
+// written by builder pods, and stored as they upload them.
+var encryptedSuffixes = []string{"/tar"}
+
+// encryptionHeader records how an object was encrypted. Every object has its own random data key,
+// sealed with the key KeyID of the keyring, so keys can be rotated without rewriting objects. Keys
+// and content are sealed with AES-256-GCM.
+type encryptionHeader struct {
+	KeyID string `json:"keyID"`
+	// WrappedKey is the data key of the object, sealed with KeyNonce
+	WrappedKey []byte `json:"wrappedKey"`
+	KeyNonce   []byte `json:"keyNonce"`
+	// Nonce is the nonce the content was sealed with
+	Nonce []byte `json:"nonce"`
+}
+
+// Keyring holds the keys objects are encrypted with, by ID. New objects are encrypted with the
+// key CurrentID; the others are kept to decrypt objects encrypted before a rotation.
+type Keyring struct {
+	CurrentID string
+	Keys      map[string][]byte
+}
+
+// LoadKeyring reads the keys in dir, laid out like the secret at EncryptionKeysLocation, and
+// returns a keyring encrypting new objects with the key currentID.
+func LoadKeyring(dir, currentID string) (*Keyring, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading encryption keys from %s (%s)", dir, err)
+	}
+	keyring := &Keyring{CurrentID: currentID, Keys: make(map[string][]byte)}
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), "..") {
+			continue
+		}
+		raw, err := ioutil.ReadFile(dir + "/" + file.Name())
+		if err != nil {
+			return nil, err
+		}
+		key := raw
+		if len(key) != encryptionKeySize {
+			if key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw))); err != nil || len(key) != encryptionKeySize {
+				return nil, fmt.Errorf("encryption key %s is not %d bytes, raw or base64 encoded", file.Name(), encryptionKeySize)
+			}
+		}
+		keyring.Keys[file.Name()] = key
+	}
+	if _, ok := keyring.Keys[currentID]; !ok {
+		return nil, fmt.Errorf("encryption key %s not found in %s", currentID, dir)
+	}
+	return keyring, nil
+}
+
+// IsEncryptedKey returns true if the object at key is encrypted at rest when encryption is
+// enabled.
+func IsEncryptedKey(key string) bool {
+	for _, suffix := range encryptedSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// encrypt seals content with a new data key, itself sealed with the current key of k.
+func (k *Keyring) encrypt(content []byte) ([]byte, error) {
+	dataKey := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	keyNonce, wrappedKey, err := seal(k.Keys[k.CurrentID], dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, sealed, err := seal(dataKey, content)
+	if err != nil {
+		return nil, err
+	}
+	header := encryptionHeader{KeyID: k.CurrentID, WrappedKey: wrappedKey, KeyNonce: keyNonce, Nonce: nonce}
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	out := bytes.NewBufferString(encryptedMagic)
+	out.Write(rawHeader)
+	out.WriteByte('\n')
+	out.Write(sealed)
+	return out.Bytes(), nil
+}
+
+// decrypt opens content if it was encrypted, and returns it as is otherwise.
+func (k *Keyring) decrypt(content []byte) ([]byte, error) {
+	if !bytes.HasPrefix(content, []byte(encryptedMagic)) {
+		return content, nil
+	}
+	rest := content[len(encryptedMagic):]
+	idx := bytes.IndexByte(rest, '\n')
+	if idx < 0 {
+		return nil, fmt.Errorf("encryption header is missing")
+	}
+	header := encryptionHeader{}
+	if err := json.Unmarshal(rest[:idx], &header); err != nil {
+		return nil, fmt.Errorf("encryption header is malformed (%s)", err)
+	}
+	kek, ok := k.Keys[header.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("encryption key %s not found", header.KeyID)
+	}
+	dataKey, err := open(kek, header.KeyNonce, header.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping the data key with key %s (%s)", header.KeyID, err)
+	}
+	return open(dataKey, header.Nonce, rest[idx+1:])
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext under key with a random nonce, and returns the nonce and ciphertext.
+func seal(key, plaintext []byte) ([]byte, []byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plaintext, nil), nil
+}
+
+// open decrypts the output of seal.
+func open(key, nonce, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("nonce is %d bytes, expected %d", len(nonce), gcm.NonceSize())
+	}
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// encryptedDriver is a storage driver encrypting the objects selected by IsEncryptedKey with
+// envelope encryption, and decrypting every encrypted object it reads.
+type encryptedDriver struct {
+	storagedriver.StorageDriver
+	keyring *Keyring
+}
+
+// NewEncryptedDriver returns a storage driver writing to and reading from driver, encrypting
+// source tarballs at rest with the keys of keyring. Only GetContent, PutContent and Reader encrypt
+// and decrypt, and Writer refuses encrypted objects; the other functions, including Stat, see the
+// objects as stored.
+func NewEncryptedDriver(driver storagedriver.StorageDriver, keyring *Keyring) storagedriver.StorageDriver {
+	return &encryptedDriver{StorageDriver: driver, keyring: keyring}
+}
+
+// GetContent is the storagedriver.StorageDriver interface implementation.
+func (d *encryptedDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	content, err := d.StorageDriver.GetContent(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := d.keyring.decrypt(content)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s (%s)", path, err)
+	}
+	return plaintext, nil
+}
+
+// PutContent is the storagedriver.StorageDriver interface implementation.
+func (d *encryptedDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	if !IsEncryptedKey(path) {
+		return d.StorageDriver.PutContent(ctx, path, content)
+	}
+	encrypted, err := d.keyring.encrypt(content)
+	if err != nil {
+		return fmt.Errorf("encrypting %s (%s)", path, err)
+	}
+	return d.StorageDriver.PutContent(ctx, path, encrypted)
+}
+
+// Reader is the storagedriver.StorageDriver interface implementation. Objects that may be
+// encrypted, tarballs the builder holds in memory anyway, are read and decrypted whole.
+func (d *encryptedDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if !IsEncryptedKey(path) {
+		return d.StorageDriver.Reader(ctx, path, offset)
+	}
+	content, err := d.GetContent(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if offset > int64(len(content)) {
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset}
+	}
+	return ioutil.NopCloser(bytes.NewReader(content[offset:])), nil
+}
+
+// Writer is the storagedriver.StorageDriver interface implementation. Objects that may be
+// encrypted are sealed whole, so they can only be written with PutContent.
+func (d *encryptedDriver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	if IsEncryptedKey(path) {
+		return nil, fmt.Errorf("%s is encrypted, it can only be written whole", path)
+	}
+	return d.StorageDriver.Writer(ctx, path, append)
+}
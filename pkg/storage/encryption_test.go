@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+)
+
+func TestLoadKeyring(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "keys")
+	assert.NoErr(t, err)
+	defer os.RemoveAll(tmpDir)
+	rawKey := bytes.Repeat([]byte{1}, encryptionKeySize)
+	encodedKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, encryptionKeySize)) + "\n"
+	assert.NoErr(t, ioutil.WriteFile(filepath.Join(tmpDir, "key1"), rawKey, 0600))
+	assert.NoErr(t, ioutil.WriteFile(filepath.Join(tmpDir, "key2"), []byte(encodedKey), 0600))
+
+	keyring, err := LoadKeyring(tmpDir, "key2")
+	assert.NoErr(t, err)
+	assert.Equal(t, keyring.Keys["key1"], rawKey, "raw key")
+	assert.Equal(t, keyring.Keys["key2"], bytes.Repeat([]byte{2}, encryptionKeySize), "base64 encoded key")
+
+	_, err = LoadKeyring(tmpDir, "key3")
+	assert.True(t, err != nil, "no error received for a missing current key")
+
+	assert.NoErr(t, ioutil.WriteFile(filepath.Join(tmpDir, "short"), []byte("short"), 0600))
+	_, err = LoadKeyring(tmpDir, "key2")
+	assert.True(t, err != nil, "no error received for a short key")
+}
+
+func TestEncryptedDriver(t *testing.T) {
+	driver, err := factory.Create("inmemory", nil)
+	assert.NoErr(t, err)
+	keyring := &Keyring{
+		CurrentID: "key1",
+		Keys:      map[string][]byte{"key1": bytes.Repeat([]byte{1}, encryptionKeySize)},
+	}
+	encrypted := NewEncryptedDriver(driver, keyring)
+	tarKey := "/home/myapp:git-c3b4e4ba/tar"
+	logKey := "/home/myapp:git-c3b4e4ba/log.gz"
+
+	assert.NoErr(t, encrypted.PutContent(context.Background(), tarKey, []byte("proprietary code")))
+	assert.NoErr(t, encrypted.PutContent(context.Background(), logKey, []byte("log")))
+
+	stored, err := driver.GetContent(context.Background(), tarKey)
+	assert.NoErr(t, err)
+	assert.False(t, bytes.Contains(stored, []byte("proprietary code")), "tarball stored in clear")
+	assert.True(t, bytes.Contains(stored, []byte(`"keyID":"key1"`)), "key ID not recorded")
+	stored, err = driver.GetContent(context.Background(), logKey)
+	assert.NoErr(t, err)
+	assert.Equal(t, string(stored), "log", "log content")
+
+	// objects encrypted before a rotation are still readable
+	keyring.Keys["key2"] = bytes.Repeat([]byte{2}, encryptionKeySize)
+	keyring.CurrentID = "key2"
+	content, err := encrypted.GetContent(context.Background(), tarKey)
+	assert.NoErr(t, err)
+	assert.Equal(t, string(content), "proprietary code", "decrypted content")
+
+	reader, err := encrypted.Reader(context.Background(), tarKey, 12)
+	assert.NoErr(t, err)
+	content, err = ioutil.ReadAll(reader)
+	assert.NoErr(t, err)
+	assert.Equal(t, string(content), "code", "decrypted content from offset")
+
+	_, err = encrypted.Writer(context.Background(), tarKey, false)
+	assert.True(t, err != nil, "no error received streaming an encrypted object")
+
+	delete(keyring.Keys, "key1")
+	_, err = encrypted.GetContent(context.Background(), tarKey)
+	assert.True(t, err != nil, "no error received for a missing key")
+
+	// slugs and caches are stored as builder pods upload them
+	assert.False(t, IsEncryptedKey("/home/myapp:git-c3b4e4ba/push/slug.tgz"), "slug encrypted")
+	assert.False(t, IsEncryptedKey("/home/myapp/cache"), "cache encrypted")
+}
@@ -54,3 +54,54 @@ func (f *FakeObjectGetter) GetContent(ctx context.Context, path string) ([]byte,
 	f.Calls = append(f.Calls, FakeGetObjectCall{Path: path})
 	return f.Fn(ctx, path)
 }
+
+// ObjectPutter is a *(github.com/docker/distribution/registry/storage/driver).StorageDriver compatible interface, restricted to
+// just the PutContent function. You can use it in your code for easier unit testing without
+// any external dependencies (like access to S3).
+type ObjectPutter interface {
+	PutContent(ctx context.Context, path string, content []byte) error
+}
+
+// FakePutObjectCall represents a single call to PutContent on the FakeObjectPutter.
+type FakePutObjectCall struct {
+	Path    string
+	Content []byte
+}
+
+// FakeObjectPutter is a mock function that can be swapped in for an ObjectPutter, so you can
+// unit test your code.
+type FakeObjectPutter struct {
+	Fn    func(context.Context, string, []byte) error
+	Calls []FakePutObjectCall
+}
+
+// PutContent is the interface definition.
+func (f *FakeObjectPutter) PutContent(ctx context.Context, path string, content []byte) error {
+	f.Calls = append(f.Calls, FakePutObjectCall{Path: path, Content: content})
+	return f.Fn(ctx, path, content)
+}
+
+// ObjectDeleter is a *(github.com/docker/distribution/registry/storage/driver).StorageDriver compatible interface, restricted to
+// just the Delete function. You can use it in your code for easier unit testing without
+// any external dependencies (like access to S3).
+type ObjectDeleter interface {
+	Delete(ctx context.Context, path string) error
+}
+
+// FakeDeleteObjectCall represents a single call to Delete on the FakeObjectDeleter.
+type FakeDeleteObjectCall struct {
+	Path string
+}
+
+// FakeObjectDeleter is a mock function that can be swapped in for an ObjectDeleter, so you can
+// unit test your code.
+type FakeObjectDeleter struct {
+	Fn    func(context.Context, string) error
+	Calls []FakeDeleteObjectCall
+}
+
+// Delete is the interface definition.
+func (f *FakeObjectDeleter) Delete(ctx context.Context, path string) error {
+	f.Calls = append(f.Calls, FakeDeleteObjectCall{Path: path})
+	return f.Fn(ctx, path)
+}
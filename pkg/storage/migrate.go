@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/context"
+	storagedriver "github.com/docker/distribution/registry/storage/driver"
+)
+
+// BuilderRoot is the folder holding every object the builder owns in object storage: the source
+// tarballs, slugs, Procfiles, logs and manifests of builds, and the build caches of apps.
+const BuilderRoot = "home"
+
+// MigrateOptions configures Migrate.
+type MigrateOptions struct {
+	// DryRun lists the objects that would be copied without copying them
+	DryRun bool
+	// StatePath is the path of a local file recording the checksums of the objects already copied
+	// and checked, in the format of sha256sum. Objects listed in it are skipped, so an interrupted
+	// migration resumes where it stopped. No state is kept if it's empty.
+	StatePath string
+}
+
+// MigrateStats counts the objects handled by Migrate.
+type MigrateStats struct {
+	Copied  int
+	Skipped int
+	Bytes   int64
+}
+
+// Migrate copies every object under root from src to dst, writing a line per object to out.
+// Objects are streamed one at a time, and Migrate fails if the size of a copy in dst differs from
+// the one of the object in src. Objects that are already in dst with the same size, or listed in
+// the state file, are not written again.
+func Migrate(src, dst storagedriver.StorageDriver, root string, opts MigrateOptions, out io.Writer) (MigrateStats, error) {
+	stats := MigrateStats{}
+	keys, err := listObjects(src, root)
+	if err != nil {
+		return stats, fmt.Errorf("listing %s (%s)", root, err)
+	}
+
+	if opts.DryRun {
+		for _, key := range keys {
+			info, err := src.Stat(context.Background(), key)
+			if err != nil {
+				return stats, fmt.Errorf("getting info of %s (%s)", key, err)
+			}
+			fmt.Fprintf(out, "%s %d\n", key, info.Size())
+			stats.Bytes += info.Size()
+		}
+		return stats, nil
+	}
+
+	done := make(map[string]string)
+	var state io.Writer = ioutil.Discard
+	if opts.StatePath != "" {
+		if done, err = readMigrateState(opts.StatePath); err != nil {
+			return stats, err
+		}
+		stateFile, err := os.OpenFile(opts.StatePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return stats, fmt.Errorf("opening %s (%s)", opts.StatePath, err)
+		}
+		defer stateFile.Close()
+		state = stateFile
+	}
+
+	for _, key := range keys {
+		if _, ok := done[key]; ok {
+			stats.Skipped++
+			continue
+		}
+		sum, size, copied, err := copyObject(src, dst, key)
+		if err != nil {
+			return stats, err
+		}
+		if copied {
+			stats.Copied++
+			stats.Bytes += size
+			fmt.Fprintf(out, "copied %s (%d bytes, sha256 %s)\n", key, size, sum)
+		} else {
+			stats.Skipped++
+			fmt.Fprintf(out, "skipped %s, already in the destination\n", key)
+		}
+		if _, err := fmt.Fprintf(state, "%s  %s\n", sum, key); err != nil {
+			return stats, fmt.Errorf("writing to %s (%s)", opts.StatePath, err)
+		}
+	}
+	return stats, nil
+}
+
+// listObjects returns the paths of every object under root in driver, sorted. Like the output of
+// List(), they have a prepended /.
+func listObjects(driver storagedriver.StorageDriver, root string) ([]string, error) {
+	children, err := driver.List(context.Background(), root)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var keys []string
+	for _, child := range children {
+		info, err := driver.Stat(context.Background(), child)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			keys = append(keys, child)
+			continue
+		}
+		childKeys, err := listObjects(driver, child)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, childKeys...)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// copyObject streams key from src to dst unless dst already has an object of the same size, and
+// checks the size of the copy. It returns the checksum and size of the object, and whether it was
+// copied. The checksum is computed while reading the object from src.
+func copyObject(src, dst storagedriver.StorageDriver, key string) (string, int64, bool, error) {
+	info, err := src.Stat(context.Background(), key)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("getting info of %s (%s)", key, err)
+	}
+	size := info.Size()
+	reader, err := src.Reader(context.Background(), key, 0)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("reading %s (%s)", key, err)
+	}
+	defer reader.Close()
+	hash := sha256.New()
+
+	if existing, err := dst.Stat(context.Background(), key); err == nil && existing.Size() == size {
+		if _, err := io.Copy(hash, reader); err != nil {
+			return "", 0, false, fmt.Errorf("reading %s (%s)", key, err)
+		}
+		return hex.EncodeToString(hash.Sum(nil)), size, false, nil
+	}
+
+	writer, err := dst.Writer(context.Background(), key, false)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("writing %s (%s)", key, err)
+	}
+	if _, err := io.Copy(writer, io.TeeReader(reader, hash)); err != nil {
+		writer.Cancel()
+		writer.Close()
+		return "", 0, false, fmt.Errorf("copying %s (%s)", key, err)
+	}
+	if err := writer.Commit(); err != nil {
+		writer.Close()
+		return "", 0, false, fmt.Errorf("writing %s (%s)", key, err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", 0, false, fmt.Errorf("writing %s (%s)", key, err)
+	}
+	copied, err := dst.Stat(context.Background(), key)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("getting info of the copy of %s (%s)", key, err)
+	}
+	if copied.Size() != size {
+		return "", 0, false, fmt.Errorf("size mismatch for %s: expected %d bytes, got %d", key, size, copied.Size())
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, true, nil
+}
+
+// readMigrateState returns the checksums of the objects listed in the state file at path, by
+// key. A missing file is an empty state.
+func readMigrateState(path string) (map[string]string, error) {
+	done := make(map[string]string)
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading %s (%s)", path, err)
+	}
+	lines := strings.Split(string(raw), "\n")
+	// the last element is empty, or a line left partial by an interrupted write
+	for _, line := range lines[:len(lines)-1] {
+		fields := strings.SplitN(line, "  ", 2)
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			continue
+		}
+		done[fields[1]] = fields[0]
+	}
+	return done, nil
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/registry/storage/driver/factory"
+	_ "github.com/docker/distribution/registry/storage/driver/inmemory"
+)
+
+func TestMigrate(t *testing.T) {
+	src, err := factory.Create("inmemory", nil)
+	assert.NoErr(t, err)
+	dst, err := factory.Create("inmemory", nil)
+	assert.NoErr(t, err)
+	objects := map[string]string{
+		"/home/myapp:git-c3b4e4ba/tar":              "tar",
+		"/home/myapp:git-c3b4e4ba/push/slug.tgz":    "slug",
+		"/home/myapp:git-c3b4e4ba/push/Procfile":    "web: example-go",
+		"/home/myapp/cache":                         "cache",
+		"/home/myapp/cache.json":                    "{}",
+		"/registry/docker/registry/v2/repositories": "not owned by the builder",
+	}
+	for key, content := range objects {
+		assert.NoErr(t, src.PutContent(context.Background(), key, []byte(content)))
+	}
+	// already copied by a previous run
+	assert.NoErr(t, dst.PutContent(context.Background(), "/home/myapp/cache", []byte("cache")))
+	// left partial by an interrupted run
+	assert.NoErr(t, dst.PutContent(context.Background(), "/home/myapp/cache.json", []byte("{")))
+
+	tmpDir, err := ioutil.TempDir("", "migrate")
+	assert.NoErr(t, err)
+	defer os.RemoveAll(tmpDir)
+	opts := MigrateOptions{StatePath: filepath.Join(tmpDir, "state")}
+
+	out := new(bytes.Buffer)
+	stats, err := Migrate(src, dst, BuilderRoot, MigrateOptions{DryRun: true}, out)
+	assert.NoErr(t, err)
+	assert.Equal(t, strings.Count(out.String(), "\n"), 5, "number of listed objects")
+	assert.Equal(t, stats.Copied, 0, "copied objects")
+	_, err = dst.Stat(context.Background(), "/home/myapp:git-c3b4e4ba/tar")
+	assert.True(t, err != nil, "dry run copied an object")
+
+	stats, err = Migrate(src, dst, BuilderRoot, opts, ioutil.Discard)
+	assert.NoErr(t, err)
+	assert.Equal(t, stats, MigrateStats{Copied: 4, Skipped: 1, Bytes: 24}, "stats")
+	for key, content := range objects {
+		if !strings.HasPrefix(key, "/home/") {
+			continue
+		}
+		copied, err := dst.GetContent(context.Background(), key)
+		assert.NoErr(t, err)
+		assert.Equal(t, string(copied), content, "content of "+key)
+	}
+	_, err = dst.Stat(context.Background(), "/registry/docker/registry/v2/repositories")
+	assert.True(t, err != nil, "copied an object outside of the builder root")
+
+	// resuming with the state file doesn't read anything again
+	stats, err = Migrate(src, dst, BuilderRoot, opts, ioutil.Discard)
+	assert.NoErr(t, err)
+	assert.Equal(t, stats, MigrateStats{Skipped: 5}, "stats")
+}
+
+func TestReadMigrateState(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "migrate")
+	assert.NoErr(t, err)
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "state")
+
+	done, err := readMigrateState(path)
+	assert.NoErr(t, err)
+	assert.Equal(t, len(done), 0, "number of migrated objects")
+
+	sum := Checksum([]byte("cache"))
+	state := sum + "  /home/myapp/cache\n" + sum + "  /home/myapp/cache.js"
+	assert.NoErr(t, ioutil.WriteFile(path, []byte(state), 0600))
+	done, err = readMigrateState(path)
+	assert.NoErr(t, err)
+	assert.Equal(t, done, map[string]string{"/home/myapp/cache": sum}, "migrated objects")
+}